@@ -0,0 +1,46 @@
+package blobstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned when the requested payload does not exist in the store
+var ErrNotFound = errors.New("payload not found")
+
+// Store is the interface to the storage offloaded payloads are written to
+type Store interface {
+	// Put stores the payload under the given key
+	Put(ctx context.Context, key string, payload []byte) error
+
+	// Get returns the payload stored under the given key
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Delete removes the payload stored under the given key
+	Delete(ctx context.Context, key string) error
+}
+
+// Pointer is the message published in place of an offloaded payload
+type Pointer struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+}
+
+// Offload stores the payload under a new random key and returns the pointer to publish in its place
+func Offload(ctx context.Context, store Store, payload []byte) ([]byte, error) {
+	pointer := Pointer{Key: uuid.New().String(), Size: len(payload)}
+	if err := store.Put(ctx, pointer.Key, payload); err != nil {
+		return nil, err
+	}
+	return json.Marshal(pointer)
+}
+
+// ParsePointer returns the pointer published in place of an offloaded payload
+func ParsePointer(pointerMessage []byte) (Pointer, error) {
+	var pointer Pointer
+	err := json.Unmarshal(pointerMessage, &pointer)
+	return pointer, err
+}
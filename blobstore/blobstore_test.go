@@ -0,0 +1,88 @@
+package blobstore
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.TODO()
+	payload := []byte(`{"msg":"large message"}`)
+
+	require.NoError(t, store.Put(ctx, "payloads/1", payload))
+	stored, err := store.Get(ctx, "payloads/1")
+	require.NoError(t, err)
+	require.Equal(t, payload, stored)
+
+	require.NoError(t, store.Delete(ctx, "payloads/1"))
+	_, err = store.Get(ctx, "payloads/1")
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestFileStore(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+	testStore(t, store)
+
+	require.Error(t, store.Put(context.TODO(), "../escape", []byte("payload")))
+	require.Error(t, store.Put(context.TODO(), "", []byte("payload")))
+	require.NoError(t, store.Delete(context.TODO(), "missing"))
+}
+
+func TestFileStorePath(t *testing.T) {
+	for _, dir := range []string{"/", "", "payloads"} {
+		path, err := NewFileStore(dir).path("key")
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dir, "key"), path)
+	}
+
+	for _, dir := range []string{"", "payloads"} {
+		_, err := NewFileStore(dir).path("../key")
+		require.Error(t, err)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	store := NewS3(S3Config{Bucket: "myBucket", KeyPrefix: "htsqs/"})
+	mock := &s3Mock{objects: make(map[string][]byte)}
+	store.s3 = mock
+	testStore(t, store)
+
+	require.NoError(t, store.Put(context.TODO(), "1", []byte("payload")))
+	require.Contains(t, mock.objects, "myBucket/htsqs/1")
+}
+
+func TestOffloadAndParsePointer(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+	payload := []byte(`{"msg":"large message"}`)
+
+	pointerMessage, err := Offload(context.TODO(), store, payload)
+	require.NoError(t, err)
+
+	var pointer Pointer
+	require.NoError(t, json.Unmarshal(pointerMessage, &pointer))
+	require.NotEmpty(t, pointer.Key)
+	require.Equal(t, len(payload), pointer.Size)
+
+	parsed, err := ParsePointer(pointerMessage)
+	require.NoError(t, err)
+	require.Equal(t, pointer, parsed)
+	resolved, err := store.Get(context.TODO(), parsed.Key)
+	require.NoError(t, err)
+	require.Equal(t, payload, resolved)
+}
+
+func TestS3StoreDefaults(t *testing.T) {
+	cfg := S3Config{Bucket: "myBucket"}
+	defaultS3Config(&cfg)
+	require.NotNil(t, cfg.AWSSession)
+
+	sess := session.Must(session.NewSession())
+	cfg = S3Config{AWSSession: sess}
+	defaultS3Config(&cfg)
+	require.Equal(t, sess, cfg.AWSSession)
+}
@@ -0,0 +1,14 @@
+// Package blobstore provides the storage large message payloads are offloaded to when they exceed
+// the maximum message size allowed by AWS SNS and AWS SQS (claim-check pattern).
+//
+// Publishers write the payload to the store and publish a pointer to it, and subscribers transparently
+// resolve the pointer back into the original payload.
+//
+// AWS S3 Store
+//
+// Stores payloads as objects of an AWS S3 bucket. For more information about to AWS S3 go to https://aws.amazon.com/s3/
+//
+// File Store
+//
+// Stores payloads as files of a local directory. Mostly useful for testing
+package blobstore
@@ -0,0 +1,67 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore is the local filesystem payload store
+type FileStore struct {
+	dir string
+}
+
+// Put writes the payload to a file of the store directory
+func (s *FileStore) Put(ctx context.Context, key string, payload []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, payload, 0o644)
+}
+
+// Get reads the payload from a file of the store directory
+func (s *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return payload, err
+}
+
+// Delete removes the file holding the payload from the store directory
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path returns the path of the file holding the payload, making sure it stays within the store directory
+func (s *FileStore) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	rel, err := filepath.Rel(filepath.Clean(s.dir), path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid payload key %q", key)
+	}
+	return path, nil
+}
+
+// NewFileStore creates a new payload store writing the payloads to files of the given directory
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
@@ -0,0 +1,37 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type s3Mock struct {
+	objects map[string][]byte
+}
+
+func (s *s3Mock) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.objects[*input.Bucket+"/"+*input.Key] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (s *s3Mock) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	b, ok := s.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (s *s3Mock) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	delete(s.objects, *input.Bucket+"/"+*input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
@@ -0,0 +1,88 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// objectStorage is the interface to s3.S3. Its sole purpose is to make
+// S3Store.s3 an interface that we can mock for testing.
+type objectStorage interface {
+	PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error)
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error)
+}
+
+// S3Config holds the info required to store payloads in AWS S3
+type S3Config struct {
+
+	// AWS session
+	AWSSession *session.Session
+
+	// S3 bucket the payloads are stored in
+	Bucket string
+
+	// Prefix prepended to the key of every stored payload
+	KeyPrefix string
+}
+
+// S3Store is the AWS S3 payload store
+type S3Store struct {
+	s3  objectStorage
+	cfg S3Config
+}
+
+// Put stores the payload as an object of the AWS S3 bucket
+func (s *S3Store) Put(ctx context.Context, key string, payload []byte) error {
+	_, err := s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Body:   bytes.NewReader(payload),
+		Bucket: &s.cfg.Bucket,
+		Key:    aws.String(s.cfg.KeyPrefix + key),
+	})
+	return err
+}
+
+// Get returns the payload stored as an object of the AWS S3 bucket
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	output, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &s.cfg.Bucket,
+		Key:    aws.String(s.cfg.KeyPrefix + key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	return io.ReadAll(output.Body)
+}
+
+// Delete removes the object holding the payload from the AWS S3 bucket
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: &s.cfg.Bucket,
+		Key:    aws.String(s.cfg.KeyPrefix + key),
+	})
+	return err
+}
+
+func defaultS3Config(cfg *S3Config) {
+	if cfg.AWSSession == nil {
+		cfg.AWSSession = session.Must(session.NewSession())
+	}
+}
+
+// NewS3 creates a new AWS S3 payload store
+func NewS3(cfg S3Config) *S3Store {
+	defaultS3Config(&cfg)
+	return &S3Store{cfg: cfg, s3: s3.New(cfg.AWSSession)}
+}
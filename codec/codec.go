@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"sync"
+	"unicode/utf8"
+)
+
+// Content types of the built-in codecs
+const (
+	JSONContentType        = "application/json"
+	ProtobufContentType    = "application/x-protobuf"
+	MessagePackContentType = "application/msgpack"
+)
+
+// Base64Encoding is the content encoding of payloads that are not valid AWS SNS and SQS message text
+// and are therefore base64 encoded before being published
+const Base64Encoding = "base64"
+
+// Codec marshals message payloads and unmarshals them back
+type Codec interface {
+	// Marshal encodes the value into a payload
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes the payload into the value pointed to by v
+	Unmarshal(data []byte, v interface{}) error
+
+	// ContentType identifies the codec
+	ContentType() string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{
+		JSONContentType:        JSON,
+		ProtobufContentType:    Protobuf,
+		MessagePackContentType: MessagePack,
+	}
+)
+
+// Register makes a codec available by its content type, replacing any codec registered with the same content type
+func Register(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[c.ContentType()] = c
+}
+
+// ForContentType returns the codec registered for the given content type
+func ForContentType(contentType string) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[contentType]
+	return c, ok
+}
+
+// IsText reports whether the payload only holds characters allowed in AWS SNS and SQS messages:
+// #x9 | #xA | #xD | #x20 to #xD7FF | #xE000 to #xFFFD | #x10000 to #x10FFFF
+func IsText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 {
+			return false
+		}
+		if !(r == 0x9 || r == 0xA || r == 0xD || (r >= 0x20 && r <= 0xD7FF) ||
+			(r >= 0xE000 && r <= 0xFFFD) || (r >= 0x10000 && r <= 0x10FFFF)) {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
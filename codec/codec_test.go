@@ -0,0 +1,88 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type message struct {
+	Msg string `json:"msg" msgpack:"msg"`
+}
+
+func TestCodecs(t *testing.T) {
+	tt := []struct {
+		name        string
+		codec       Codec
+		contentType string
+	}{
+		{"JSON", JSON, JSONContentType},
+		{"MessagePack", MessagePack, MessagePackContentType},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.contentType, tc.codec.ContentType())
+
+			data, err := tc.codec.Marshal(message{Msg: "message"})
+			require.NoError(t, err)
+
+			var decoded message
+			require.NoError(t, tc.codec.Unmarshal(data, &decoded))
+			require.Equal(t, message{Msg: "message"}, decoded)
+		})
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	require.Equal(t, ProtobufContentType, Protobuf.ContentType())
+
+	data, err := Protobuf.Marshal(wrapperspb.String("message"))
+	require.NoError(t, err)
+
+	decoded := &wrapperspb.StringValue{}
+	require.NoError(t, Protobuf.Unmarshal(data, decoded))
+	require.True(t, proto.Equal(wrapperspb.String("message"), decoded))
+
+	_, err = Protobuf.Marshal(message{Msg: "message"})
+	require.EqualError(t, err, "protobuf codec: codec.message does not implement proto.Message")
+	require.Error(t, Protobuf.Unmarshal(data, &message{}))
+}
+
+type customCodec struct {
+	jsonCodec
+}
+
+func (customCodec) ContentType() string {
+	return "application/custom"
+}
+
+func TestRegistry(t *testing.T) {
+	for _, c := range []Codec{JSON, Protobuf, MessagePack} {
+		registered, ok := ForContentType(c.ContentType())
+		require.True(t, ok)
+		require.Equal(t, c, registered)
+	}
+
+	_, ok := ForContentType("application/custom")
+	require.False(t, ok)
+
+	Register(customCodec{})
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, "application/custom")
+		registryMu.Unlock()
+	})
+	registered, ok := ForContentType("application/custom")
+	require.True(t, ok)
+	require.Equal(t, customCodec{}, registered)
+}
+
+func TestIsText(t *testing.T) {
+	require.True(t, IsText([]byte(`{"msg":"message ✓"}`)))
+	require.True(t, IsText([]byte("tab\tand\nnew line")))
+	require.False(t, IsText([]byte{0x0, 0x1}))
+	require.False(t, IsText([]byte{0xff, 0xfe}))
+}
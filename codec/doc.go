@@ -0,0 +1,6 @@
+// Package codec provides the encodings message payloads are marshaled with before being published
+// and unmarshaled with once consumed.
+//
+// The content type of the codec is recorded in a message attribute so that subscribers
+// can decode the payload with the same codec it was published with.
+package codec
@@ -0,0 +1,20 @@
+package codec
+
+import "encoding/json"
+
+// JSON is the encoding/json codec, the default codec of publishers and subscribers
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) ContentType() string {
+	return JSONContentType
+}
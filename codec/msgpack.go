@@ -0,0 +1,20 @@
+package codec
+
+import "github.com/vmihailenco/msgpack/v5"
+
+// MessagePack is the MessagePack codec
+var MessagePack Codec = messagePackCodec{}
+
+type messagePackCodec struct{}
+
+func (messagePackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (messagePackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (messagePackCodec) ContentType() string {
+	return MessagePackContentType
+}
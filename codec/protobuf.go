@@ -0,0 +1,32 @@
+package codec
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf is the protocol buffers codec. Values must implement proto.Message
+var Protobuf Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (protobufCodec) ContentType() string {
+	return ProtobufContentType
+}
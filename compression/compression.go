@@ -0,0 +1,141 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings of the built-in compressors
+const (
+	GzipEncoding = "gzip"
+	ZstdEncoding = "zstd"
+)
+
+// MaxDecompressedSize is the maximum size in bytes of a payload decompressed by the built-in compressors,
+// protecting subscribers against payloads decompressing to huge sizes
+const MaxDecompressedSize = 64 << 20
+
+// ErrTooLarge is returned when decompressing a payload larger than MaxDecompressedSize
+var ErrTooLarge = errors.New("decompressed payload exceeds the maximum size")
+
+// Compressor compresses payloads and decompresses them back
+type Compressor interface {
+	// Compress returns the compressed payload
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress returns the original payload of the compressed one
+	Decompress(data []byte) ([]byte, error)
+
+	// Encoding identifies the compression algorithm
+	Encoding() string
+}
+
+var (
+	// Gzip is the gzip compressor
+	Gzip Compressor = gzipCompressor{}
+
+	// Zstd is the Zstandard compressor
+	Zstd Compressor = &zstdCompressor{}
+
+	registryMu sync.RWMutex
+	registry   = map[string]Compressor{
+		GzipEncoding: Gzip,
+		ZstdEncoding: Zstd,
+	}
+)
+
+// Register makes a compressor available by its encoding, replacing any compressor registered with the same encoding.
+// Custom compressors set on a publisher must be registered on the subscriber side to be decompressed
+func Register(c Compressor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[c.Encoding()] = c
+}
+
+// ForEncoding returns the compressor registered for the given encoding
+func ForEncoding(encoding string) (Compressor, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[encoding]
+	return c, ok
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return decompressed, nil
+}
+
+func (gzipCompressor) Encoding() string {
+	return GzipEncoding
+}
+
+// zstdCompressor lazily creates a single encoder and decoder, both safe for concurrent use
+type zstdCompressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		if c.encoder, c.err = zstd.NewWriter(nil); c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+	})
+	return c.err
+}
+
+func (c *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	decompressed, err := c.decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, ErrTooLarge
+	}
+	return decompressed, err
+}
+
+func (c *zstdCompressor) Encoding() string {
+	return ZstdEncoding
+}
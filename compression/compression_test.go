@@ -0,0 +1,67 @@
+package compression
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressors(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"msg":"repetitive message"}`), 100)
+
+	for _, encoding := range []string{GzipEncoding, ZstdEncoding} {
+		t.Run(encoding, func(t *testing.T) {
+			c, ok := ForEncoding(encoding)
+			require.True(t, ok)
+			require.Equal(t, encoding, c.Encoding())
+
+			compressed, err := c.Compress(payload)
+			require.NoError(t, err)
+			require.Less(t, len(compressed), len(payload))
+
+			decompressed, err := c.Decompress(compressed)
+			require.NoError(t, err)
+			require.Equal(t, payload, decompressed)
+
+			_, err = c.Decompress([]byte("not compressed"))
+			require.Error(t, err)
+		})
+	}
+
+	_, ok := ForEncoding("br")
+	require.False(t, ok)
+}
+
+func TestDecompressMaxSize(t *testing.T) {
+	payload := make([]byte, MaxDecompressedSize+1)
+
+	for _, c := range []Compressor{Gzip, Zstd} {
+		t.Run(c.Encoding(), func(t *testing.T) {
+			compressed, err := c.Compress(payload)
+			require.NoError(t, err)
+
+			_, err = c.Decompress(compressed)
+			require.Equal(t, ErrTooLarge, err)
+		})
+	}
+}
+
+type customCompressor struct{}
+
+func (customCompressor) Compress(data []byte) ([]byte, error)   { return data, nil }
+func (customCompressor) Decompress(data []byte) ([]byte, error) { return data, nil }
+func (customCompressor) Encoding() string                       { return "custom" }
+
+func TestRegister(t *testing.T) {
+	Register(customCompressor{})
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, "custom")
+		registryMu.Unlock()
+	})
+
+	c, ok := ForEncoding("custom")
+	require.True(t, ok)
+	require.Equal(t, customCompressor{}, c)
+}
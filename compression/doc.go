@@ -0,0 +1,7 @@
+// Package compression provides the compression algorithms message payloads can be compressed with
+// before being published.
+//
+// The encoding of the algorithm is recorded in the content encoding message attribute so that
+// subscribers transparently decompress the payload before handing it to the message handlers.
+// Custom compressors are made available to subscribers with Register.
+package compression
@@ -0,0 +1,36 @@
+package constants
+
+import "time"
+
+const (
+	// ReservedAttributePrefix is the prefix of the message attributes set by htsqs, which messages cannot set.
+	// These attributes count towards the AWS limit of 10 message attributes: the content type of every message,
+	// along with the content encoding of compressed or base64 encoded payloads, the deliver-at time of messages
+	// delayed longer than MaxDelay and the size of offloaded payloads
+	ReservedAttributePrefix = "htsqs."
+
+	// DeliverAtAttribute is the message attribute holding the time, in unix milliseconds, a message delayed
+	// longer than MaxDelay must be delivered at. The subscriber re-enqueues these messages until then
+	DeliverAtAttribute = "htsqs.deliver-at"
+
+	// ContentTypeAttribute is the message attribute holding the content type of the codec the payload was marshaled with
+	ContentTypeAttribute = "htsqs.content-type"
+
+	// ContentEncodingAttribute is the message attribute holding the comma separated list of encodings applied
+	// to the payload, in the order they were applied
+	ContentEncodingAttribute = "htsqs.content-encoding"
+
+	// OffloadedPayloadAttribute is the message attribute holding the size of a payload offloaded to a blob store.
+	// The body of these messages is the pointer to the stored payload
+	OffloadedPayloadAttribute = "htsqs.offloaded-payload-size"
+
+	// DeadLetterReasonAttribute is the message attribute holding the handler error of a message sent to a dead-letter queue
+	DeadLetterReasonAttribute = "htsqs.dead-letter-reason"
+)
+
+const (
+	MaxDelay       = 15 * time.Minute // 15 minutes is the maximum delay of a message sent to AWS SQS
+	MaxPayloadSize = 256 * 1024       // 256 KB is the maximum size of a message published to AWS SNS and AWS SQS
+
+	MaxVisibilityTimeout = 12 * time.Hour // 12 hours is the maximum visibility timeout of an AWS SQS message
+)
@@ -1,5 +1,10 @@
 package constants
 
+import (
+	"errors"
+	"fmt"
+)
+
 const (
 	GenericPublishError = "GenericPublishError"
 )
@@ -7,3 +12,51 @@ const (
 var ErrorStrings = map[string]string{
 	GenericPublishError: "publish error",
 }
+
+var (
+	// ErrFifoOnlyField is returned when a message group ID or a deduplication ID is set
+	// on a message published to a standard (non FIFO) queue or topic
+	ErrFifoOnlyField = errors.New("message group ID and deduplication ID are only supported by FIFO queues and topics")
+
+	// ErrFifoDelay is returned when a per-message delay is set on a message published to a FIFO queue
+	ErrFifoDelay = errors.New("per-message delays are not supported by FIFO queues")
+
+	// ErrDelayNotSupported is returned when a per-message delay is set on a message published to AWS SNS
+	ErrDelayNotSupported = errors.New("per-message delays are not supported by AWS SNS")
+
+	// ErrTooManyAttributes is returned when a message has more message attributes than allowed by AWS,
+	// the attributes reserved by htsqs included
+	ErrTooManyAttributes = errors.New("a message can have at most 10 message attributes")
+
+	// ErrInvalidAttributeName is returned when a message attribute name does not comply with the AWS naming rules
+	ErrInvalidAttributeName = errors.New("invalid message attribute name")
+
+	// ErrInvalidAttributeValue is returned when a message attribute value does not match its data type
+	ErrInvalidAttributeValue = errors.New("invalid message attribute value")
+
+	// ErrChecksumMismatch is returned when the MD5 digest of the message body returned by AWS SQS
+	// does not match the message body sent
+	ErrChecksumMismatch = errors.New("MD5 of the message body returned by AWS SQS does not match the message body sent")
+
+	// ErrInvalidBatchEntryID is returned when the ID of a message published in a batch is longer than 80 characters
+	// or holds characters other than alphanumeric characters, hyphens and underscores
+	ErrInvalidBatchEntryID = errors.New("batch entry IDs must be up to 80 alphanumeric, hyphen or underscore characters")
+
+	// ErrDuplicateBatchEntryID is returned when several messages published in a batch share the same ID
+	ErrDuplicateBatchEntryID = errors.New("batch entry IDs must be unique")
+)
+
+// MessageTooLargeError is returned when a message, attributes included, is larger than allowed by AWS.
+// The message is rejected before being sent
+type MessageTooLargeError struct {
+	ID      string
+	Size    int
+	MaxSize int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("message of %d bytes exceeds the maximum message size of %d bytes", e.Size, e.MaxSize)
+	}
+	return fmt.Sprintf("message %s of %d bytes exceeds the maximum message size of %d bytes", e.ID, e.Size, e.MaxSize)
+}
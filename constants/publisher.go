@@ -1,5 +1,13 @@
 package constants
 
 const (
-	MaxBatchSize = 10 // 10 is the maximum batch size for SNS.PublishBatch
+	MaxBatchSize         = 10 // 10 is the maximum batch size for SNS.PublishBatch
+	MaxMessageAttributes = 10 // 10 is the maximum number of message attributes per message
+
+	MaxBatchPayloadSize = 256 * 1024 // 256 KB is the maximum aggregate size of the messages of a batch
+
+	FifoSuffix            = ".fifo"   // FIFO queue and topic names must end with the .fifo suffix
+	DefaultMessageGroupID = "default" // message group used when no group ID is supplied for a FIFO message
+
+	DefaultCompressionThreshold = 1024 // payloads larger than 1 KB are compressed when compression is enabled
 )
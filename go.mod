@@ -1,17 +1,23 @@
 module github.com/creatorstack/htsqs
 
-go 1.17
+// Go 1.22 is the minimum version required by github.com/klauspost/compress v1.18.0,
+// the zstd implementation of the compression package
+go 1.22
 
 require (
 	github.com/aws/aws-sdk-go v1.43.24
+	github.com/google/uuid v1.3.0
 	github.com/jpillora/backoff v1.0.0
+	github.com/klauspost/compress v1.18.0
 	github.com/stretchr/testify v1.7.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	google.golang.org/protobuf v1.28.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
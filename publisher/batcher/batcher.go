@@ -0,0 +1,268 @@
+package batcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher"
+	"github.com/creatorstack/htsqs/publisher/models"
+)
+
+const (
+	// defaultLinger is the time a message waits for its batch to fill up before being published
+	defaultLinger = 10 * time.Millisecond
+)
+
+// ErrClosed is returned when publishing through a Batcher after a call to 'Close'
+var ErrClosed = errors.New("batcher closed")
+
+// Config holds the batching settings
+type Config struct {
+
+	// maximum number of messages per batch. Defaults to 10, the maximum allowed by AWS
+	MaxBatchSize int
+
+	// maximum size in bytes of a batch. Defaults to 256 KB, the maximum allowed by AWS
+	MaxBatchBytes int
+
+	// maximum time a message waits for its batch to fill up before the batch is published
+	Linger time.Duration
+
+	// SizeFunc estimates the size in bytes of a message. Defaults to the size of its JSON encoded data and attributes
+	SizeFunc func(msg models.Message) int
+}
+
+// Result is the future result of a message published asynchronously
+type Result struct {
+	done chan struct{}
+	err  error
+}
+
+// Done returns a channel that is closed once the message has been published or has failed
+func (r *Result) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the publish error of the message. It must only be called once Done is closed
+func (r *Result) Err() error {
+	return r.err
+}
+
+// Wait blocks until the message has been published, returning its publish error, or the context is done
+func (r *Result) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Result) complete(err error) {
+	r.err = err
+	close(r.done)
+}
+
+type entry struct {
+	msg    models.Message
+	size   int
+	result *Result
+}
+
+// Batcher is an asynchronous publisher that publishes messages in batches.
+// The messages of a batch are published independently from each other through publishers implementing
+// publisher.PartialBatchPublisher, otherwise an error of the whole batch is returned to each of its messages.
+// Once Close has been called on the batcher, it might not be reused;
+// future calls to publish will return ErrClosed.
+type Batcher struct {
+	pub publisher.BatchPublisher
+	cfg Config
+
+	mu           sync.Mutex
+	pending      []*entry
+	pendingBytes int
+	generation   uint64
+	sequence     uint64
+	closed       bool
+
+	// inFlight holds the channels closed once each batch being published is done
+	inFlight map[chan struct{}]struct{}
+}
+
+// Publish allows Batcher to implement the publisher.Publisher interface. It blocks until the batch
+// holding the message has been published or the context is done
+func (b *Batcher) Publish(ctx context.Context, msg interface{}) error {
+	return b.PublishAsync(models.NewMessage(msg)).Wait(ctx)
+}
+
+// PublishAsync adds the message to the current batch and returns its future result
+func (b *Batcher) PublishAsync(msg models.Message) *Result {
+	result := &Result{done: make(chan struct{})}
+	size := b.cfg.SizeFunc(msg)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		result.complete(ErrClosed)
+		return result
+	}
+
+	if len(b.pending) > 0 && b.pendingBytes+size > b.cfg.MaxBatchBytes {
+		b.flushLocked()
+	}
+
+	// batch entry IDs only need to be unique within a batch, caller IDs may collide across goroutines
+	b.sequence++
+	msg.ID = strconv.FormatUint(b.sequence, 10)
+	b.pending = append(b.pending, &entry{msg: msg, size: size, result: result})
+	b.pendingBytes += size
+
+	if len(b.pending) >= b.cfg.MaxBatchSize {
+		b.flushLocked()
+	} else if len(b.pending) == 1 {
+		generation := b.generation
+		time.AfterFunc(b.cfg.Linger, func() { b.lingerFlush(generation) })
+	}
+	return result
+}
+
+// PublishCallback adds the message to the current batch and calls back with its publish error once published
+func (b *Batcher) PublishCallback(msg models.Message, callback func(error)) {
+	result := b.PublishAsync(msg)
+	go func() {
+		<-result.Done()
+		callback(result.Err())
+	}()
+}
+
+// Flush publishes the current batch and blocks until it and every batch in flight at the time of the call
+// have been published or the context is done
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	b.flushLocked()
+	inFlight := make([]chan struct{}, 0, len(b.inFlight))
+	for done := range b.inFlight {
+		inFlight = append(inFlight, done)
+	}
+	b.mu.Unlock()
+
+	for _, done := range inFlight {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close stops accepting new messages and flushes the pending ones
+func (b *Batcher) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	return b.Flush(ctx)
+}
+
+// lingerFlush publishes the batch started when the linger timer was set, unless it has already been published
+func (b *Batcher) lingerFlush(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation {
+		b.flushLocked()
+	}
+}
+
+// flushLocked publishes the pending messages in the background. b.mu must be held
+func (b *Batcher) flushLocked() {
+	if len(b.pending) == 0 {
+		return
+	}
+
+	batch := b.pending
+	b.pending = nil
+	b.pendingBytes = 0
+	b.generation++
+
+	done := make(chan struct{})
+	b.inFlight[done] = struct{}{}
+	go func() {
+		b.send(batch)
+
+		b.mu.Lock()
+		delete(b.inFlight, done)
+		b.mu.Unlock()
+		close(done)
+	}()
+}
+
+// send publishes the batch and completes the result of each message
+func (b *Batcher) send(batch []*entry) {
+	msgs := make([]models.Message, 0, len(batch))
+	for _, e := range batch {
+		msgs = append(msgs, e.msg)
+	}
+
+	publishBatch := b.pub.PublishBatch
+	if partialPub, ok := b.pub.(publisher.PartialBatchPublisher); ok {
+		// a message rejected by the publisher must not fail the other messages of the batch
+		publishBatch = partialPub.PublishBatchPartial
+	}
+
+	publishResult, err := publishBatch(context.Background(), msgs)
+	if publishResult == nil {
+		publishResult = &models.BatchResult{}
+	}
+	for _, e := range batch {
+		msgResult, ok := publishResult.Result(e.msg.ID)
+		switch {
+		case ok:
+			e.result.complete(msgResult.Err)
+		case err != nil:
+			e.result.complete(err)
+		default:
+			e.result.complete(errors.New(constants.ErrorStrings[constants.GenericPublishError]))
+		}
+	}
+}
+
+// messageSize estimates the size of the message as the size of its JSON encoded data and attributes
+func messageSize(msg models.Message) int {
+	b, _ := json.Marshal(msg.Data)
+	return len(b) + msg.Attributes.Size()
+}
+
+func defaultBatcherConfig(cfg *Config) {
+	if cfg.MaxBatchSize <= 0 || cfg.MaxBatchSize > constants.MaxBatchSize {
+		cfg.MaxBatchSize = constants.MaxBatchSize
+	}
+
+	if cfg.MaxBatchBytes <= 0 || cfg.MaxBatchBytes > constants.MaxPayloadSize {
+		cfg.MaxBatchBytes = constants.MaxPayloadSize
+	}
+
+	if cfg.Linger <= 0 {
+		cfg.Linger = defaultLinger
+	}
+
+	if cfg.SizeFunc == nil {
+		cfg.SizeFunc = messageSize
+	}
+}
+
+// New creates a new Batcher publishing the batches through the given publisher
+func New(pub publisher.BatchPublisher, cfg Config) *Batcher {
+	defaultBatcherConfig(&cfg)
+	return &Batcher{pub: pub, cfg: cfg, inFlight: make(map[chan struct{}]struct{})}
+}
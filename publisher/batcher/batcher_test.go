@@ -0,0 +1,162 @@
+package batcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher"
+	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatcher(t *testing.T) {
+	mock := &batchPublisherMock{}
+	b := New(mock, Config{Linger: time.Hour})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 25; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := b.PublishAsync(models.Message{ID: "same", Data: "message"})
+			<-result.Done()
+			require.NoError(t, result.Err())
+		}()
+	}
+
+	require.Eventually(t, func() bool { return len(mock.batchSizes()) == 2 }, time.Second, time.Millisecond)
+	require.Equal(t, []int{10, 10}, mock.batchSizes())
+
+	require.NoError(t, b.Close(context.TODO()))
+	wg.Wait()
+	require.Equal(t, []int{10, 10, 5}, mock.batchSizes())
+	require.Equal(t, ErrClosed, b.Publish(context.TODO(), "message"))
+	require.Equal(t, ErrClosed, b.Close(context.TODO()))
+}
+
+func TestBatcherLinger(t *testing.T) {
+	mock := &batchPublisherMock{}
+	b := New(mock, Config{Linger: 5 * time.Millisecond})
+
+	require.NoError(t, b.Publish(context.TODO(), "message"))
+	require.Equal(t, []int{1}, mock.batchSizes())
+}
+
+func TestBatcherMaxBatchBytes(t *testing.T) {
+	mock := &batchPublisherMock{}
+	b := New(mock, Config{MaxBatchBytes: 25, Linger: time.Hour})
+
+	for i := 0; i < 5; i++ {
+		// every message is 10 bytes long once JSON encoded
+		b.PublishAsync(models.Message{Data: "12345678"})
+	}
+	require.NoError(t, b.Flush(context.TODO()))
+	require.ElementsMatch(t, []int{2, 2, 1}, mock.batchSizes())
+}
+
+func TestBatcherErrors(t *testing.T) {
+	mock := &batchPublisherMock{failIDs: map[string]bool{"2": true}}
+	b := New(mock, Config{Linger: time.Hour})
+
+	results := make([]*Result, 0, 3)
+	for i := 0; i < 3; i++ {
+		results = append(results, b.PublishAsync(models.Message{Data: "message"}))
+	}
+	require.NoError(t, b.Flush(context.TODO()))
+	require.NoError(t, results[0].Err())
+	require.EqualError(t, results[1].Err(), "failed")
+	require.NoError(t, results[2].Err())
+
+	mock.err = errors.New("AWS very bad error")
+	callbackErrs := make(chan error, 1)
+	b.PublishCallback(models.Message{Data: "message"}, func(err error) { callbackErrs <- err })
+	require.NoError(t, b.Flush(context.TODO()))
+	require.Equal(t, mock.err, <-callbackErrs)
+
+	// publishers may return no result along with the error
+	mock.nilResult = true
+	result := b.PublishAsync(models.Message{Data: "message"})
+	require.NoError(t, b.Flush(context.TODO()))
+	require.Equal(t, mock.err, result.Err())
+}
+
+func TestBatcherPartialErrors(t *testing.T) {
+	rejectIDs := map[string]bool{"2": true}
+
+	tt := []struct {
+		name        string
+		pub         publisher.BatchPublisher
+		expectedErr []string
+	}{
+		{"Batch publisher", &batchPublisherMock{rejectIDs: rejectIDs}, []string{"rejected", "rejected", "rejected"}},
+		{"Partial batch publisher", &partialBatchPublisherMock{batchPublisherMock{rejectIDs: rejectIDs}}, []string{"", "rejected", ""}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			b := New(tc.pub, Config{Linger: time.Hour})
+
+			results := make([]*Result, 0, 3)
+			for i := 0; i < 3; i++ {
+				results = append(results, b.PublishAsync(models.Message{Data: "message"}))
+			}
+			require.NoError(t, b.Close(context.TODO()))
+
+			for i, result := range results {
+				if tc.expectedErr[i] == "" {
+					require.NoError(t, result.Err())
+				} else {
+					require.EqualError(t, result.Err(), tc.expectedErr[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBatcherConcurrentFlush(t *testing.T) {
+	b := New(&batchPublisherMock{}, Config{Linger: time.Millisecond})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				result := b.PublishAsync(models.Message{Data: "message"})
+				require.NoError(t, b.Flush(context.TODO()))
+
+				// the batch of the message was either pending or in flight when flushing
+				select {
+				case <-result.Done():
+					require.NoError(t, result.Err())
+				default:
+					t.Error("message not published once flushed")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, b.Close(context.TODO()))
+}
+
+func TestBatcherWaitContext(t *testing.T) {
+	b := New(&batchPublisherMock{}, Config{Linger: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	require.Equal(t, context.Canceled, b.Publish(ctx, "message"))
+	require.NoError(t, b.Close(context.TODO()))
+}
+
+func TestBatcherDefaults(t *testing.T) {
+	cfg := Config{MaxBatchSize: 20}
+	defaultBatcherConfig(&cfg)
+	require.Equal(t, constants.MaxBatchSize, cfg.MaxBatchSize)
+	require.Equal(t, constants.MaxPayloadSize, cfg.MaxBatchBytes)
+	require.Equal(t, defaultLinger, cfg.Linger)
+	require.Equal(t, 10, cfg.SizeFunc(models.Message{Data: "12345678"}))
+}
@@ -0,0 +1,3 @@
+// Package batcher provides an asynchronous publisher that accumulates the messages published from many
+// goroutines into batches, published through the PublishBatch method of the SNS and SQS publishers.
+package batcher
@@ -0,0 +1,77 @@
+package batcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/creatorstack/htsqs/publisher/models"
+)
+
+type batchPublisherMock struct {
+	mu      sync.Mutex
+	batches [][]models.Message
+	failIDs map[string]bool
+	err     error
+
+	// rejectIDs are the IDs of the messages rejected before the batch is sent, stopping the whole batch
+	rejectIDs map[string]bool
+
+	// nilResult makes PublishBatch return a nil result along with err
+	nilResult bool
+}
+
+func (p *batchPublisherMock) PublishBatch(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	return p.publishBatch(msgs, false)
+}
+
+func (p *batchPublisherMock) publishBatch(msgs []models.Message, partial bool) (*models.BatchResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, msgs)
+
+	result := &models.BatchResult{}
+	if p.nilResult {
+		result = nil
+	}
+	if p.err != nil {
+		return result, p.err
+	}
+
+	for _, msg := range msgs {
+		if p.rejectIDs[msg.ID] && !partial {
+			return &models.BatchResult{}, errors.New("rejected")
+		}
+	}
+
+	for _, msg := range msgs {
+		msgResult := models.MessageResult{ID: msg.ID}
+		switch {
+		case p.rejectIDs[msg.ID]:
+			msgResult.Err = errors.New("rejected")
+		case p.failIDs[msg.ID]:
+			msgResult.Err = errors.New("failed")
+		}
+		result.Results = append(result.Results, msgResult)
+	}
+	return result, nil
+}
+
+// partialBatchPublisherMock is a batchPublisherMock implementing publisher.PartialBatchPublisher
+type partialBatchPublisherMock struct {
+	batchPublisherMock
+}
+
+func (p *partialBatchPublisherMock) PublishBatchPartial(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	return p.publishBatch(msgs, true)
+}
+
+func (p *batchPublisherMock) batchSizes() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sizes := make([]int, 0, len(p.batches))
+	for _, batch := range p.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
@@ -12,4 +12,14 @@
 // Publish messages to the given AWS SQS Queue. AWS SQS publisher will publish messages to the AWS SQS queue
 // for asynchronous message processing.
 // For more information about to AWS SQS go to https://aws.amazon.com/sqs/
+//
+// Batcher
+//
+// Accumulates the messages published from many goroutines into batches that are published through
+// the AWS SNS or AWS SQS publisher, reducing the number of API calls. See package batcher.
+//
+// Middleware
+//
+// Wraps the AWS SNS or AWS SQS publisher with a chain of middlewares intercepting its calls, e.g. for logging,
+// metrics or validation. See package middleware.
 package publisher
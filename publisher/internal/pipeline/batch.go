@@ -0,0 +1,254 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/creatorstack/htsqs/publisher/retry"
+)
+
+// Success is an entry successfully sent. Err is set when the response of AWS cannot be trusted,
+// e.g. when the MD5 digest of the message body does not match
+type Success struct {
+	ID             string
+	MessageID      string
+	SequenceNumber string
+	Err            error
+}
+
+// Failure is an entry AWS failed to send
+type Failure struct {
+	ID          string
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+// Output holds the outcome of the entries of a batch
+type Output struct {
+	Successful []Success
+	Failed     []Failure
+}
+
+// BuildFunc builds the entry a message of a batch is sent with
+type BuildFunc func(ctx context.Context, msg models.Message) (*Entry, error)
+
+// SendFunc sends the entries in a single AWS batch request and converts the response
+type SendFunc func(ctx context.Context, entries []*Entry) (*Output, error)
+
+// PublishBatch builds the entries of the messages and sends them in batches packed by both count and size,
+// re-sending failed entries according to the retry policy. Every entry is built up front so that invalid
+// and oversized messages are rejected before any is sent. The returned result lists the outcome of every
+// processed message. Unless ContinueOnError is set, publishing stops at the first error
+func PublishBatch(ctx context.Context, cfg *Config, msgs []models.Message, build BuildFunc, send SendFunc) (*models.BatchResult, error) {
+	var (
+		results   = make([]models.MessageResult, len(msgs))
+		entries   = make([]*Entry, len(msgs))
+		processed = 0
+	)
+
+	entryIDs, idErrs := models.EntryIDs(msgs)
+	for idx, msg := range msgs {
+		err := idErrs[idx]
+		if err == nil {
+			msg.ID = entryIDs[idx]
+			entries[idx], err = build(ctx, msg)
+		}
+		if err != nil {
+			if !cfg.ContinueOnError {
+				return &models.BatchResult{}, err
+			}
+			results[idx] = localResult(msgs[idx].ID, entryIDs[idx], err)
+		}
+	}
+
+	for _, indexes := range packBatches(entries) {
+		batch := make([]*Entry, 0, len(indexes))
+		for _, idx := range indexes {
+			batch = append(batch, entries[idx])
+		}
+
+		response, err := sendBatch(ctx, cfg, batch, send)
+		if err != nil && !cfg.ContinueOnError {
+			return &models.BatchResult{Results: results[:processed]}, err
+		}
+
+		outcomes := batchResults(response)
+		for _, idx := range indexes {
+			id := entries[idx].ID
+			outcome, ok := outcomes[id]
+			switch {
+			case err != nil:
+				outcome = failedResult(id, err)
+			case !ok:
+				outcome = models.MessageResult{Err: errors.New(constants.ErrorStrings[constants.GenericPublishError])}
+			}
+			outcome.ID, outcome.EntryID = msgs[idx].ID, id
+			results[idx] = outcome
+		}
+		processed = indexes[len(indexes)-1] + 1
+	}
+
+	return &models.BatchResult{Results: results}, nil
+}
+
+// packBatches groups the entries into batches holding at most 10 entries and 256 KB, the maximum allowed by AWS.
+// It returns the indexes of the entries of each batch, skipping the nil entries of the messages rejected locally
+func packBatches(entries []*Entry) [][]int {
+	var (
+		batches    [][]int
+		batch      []int
+		batchBytes int
+	)
+	for idx, entry := range entries {
+		if entry == nil {
+			continue
+		}
+
+		size := entry.Size()
+		if len(batch) == constants.MaxBatchSize || (len(batch) > 0 && batchBytes+size > constants.MaxBatchPayloadSize) {
+			batches = append(batches, batch)
+			batch, batchBytes = nil, 0
+		}
+		batch = append(batch, idx)
+		batchBytes += size
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// batchResults maps the outcome of each entry reported by AWS to its entry ID
+func batchResults(response *Output) map[string]models.MessageResult {
+	results := make(map[string]models.MessageResult)
+	if response == nil {
+		return results
+	}
+
+	for _, failure := range response.Failed {
+		errMsg := failure.Message
+		if errMsg == "" {
+			errMsg = constants.GenericPublishError
+		}
+		results[failure.ID] = models.MessageResult{
+			EntryID:     failure.ID,
+			Code:        failure.Code,
+			SenderFault: failure.SenderFault,
+			Err:         errors.New(errMsg),
+		}
+	}
+
+	for _, success := range response.Successful {
+		results[success.ID] = models.MessageResult{
+			EntryID:        success.ID,
+			MessageID:      success.MessageID,
+			SequenceNumber: success.SequenceNumber,
+			Err:            success.Err,
+		}
+	}
+	return results
+}
+
+// localResult builds the outcome of a message rejected before being sent to AWS
+func localResult(id, entryID string, err error) models.MessageResult {
+	return models.MessageResult{ID: id, EntryID: entryID, SenderFault: true, Local: true, Err: err}
+}
+
+// failedResult builds the outcome of a message that could not be published because of the error
+func failedResult(entryID string, err error) models.MessageResult {
+	result := models.MessageResult{EntryID: entryID, SenderFault: true, Err: err}
+	if awsErr, ok := err.(awserr.Error); ok {
+		result.Code = awsErr.Code()
+		result.SenderFault = !retry.IsRetryable(err)
+	}
+	return result
+}
+
+// sendBatch sends the entries in a single batch, retrying the whole call on throttling and transient errors
+// and re-sending only the failed entries worth retrying until the attempts of the retry policy are exhausted.
+// Entries still failing are reported in the Failed entries of the output. The error of the call is returned
+// when it keeps failing and no entry has been sent successfully
+func sendBatch(ctx context.Context, cfg *Config, entries []*Entry, send SendFunc) (*Output, error) {
+	var (
+		output = &Output{}
+		b      = cfg.RetryPolicy.Backoff()
+	)
+
+	for attempt := 1; ; attempt++ {
+		var pending []Failure
+
+		response, err := send(ctx, entries)
+		switch {
+		case err == nil:
+			output.Successful = append(output.Successful, response.Successful...)
+
+			retried := make([]*Entry, 0, len(response.Failed))
+			for _, failure := range response.Failed {
+				entry := findEntry(entries, failure.ID)
+				if entry == nil || !cfg.RetryPolicy.ShouldRetryEntry(attempt, failure.Code, failure.Message, failure.SenderFault) {
+					output.Failed = append(output.Failed, failure)
+					continue
+				}
+				retried = append(retried, entry)
+				pending = append(pending, failure)
+			}
+			if len(retried) == 0 {
+				return output, nil
+			}
+			entries = retried
+
+		case cfg.RetryPolicy.ShouldRetry(attempt, err):
+			pending = failedEntries(entries, err)
+
+		case len(output.Successful) == 0:
+			// the call failed as a whole, like on the first attempt
+			return nil, err
+
+		default:
+			// entries re-sent after a partial failure are reported as failed, keeping
+			// the entries successfully sent by the previous attempts
+			output.Failed = append(output.Failed, failedEntries(entries, err)...)
+			return output, nil
+		}
+
+		if sleepErr := retry.Sleep(ctx, b.Duration()); sleepErr != nil {
+			if err != nil && len(output.Successful) == 0 {
+				return nil, err
+			}
+			output.Failed = append(output.Failed, pending...)
+			return output, nil
+		}
+	}
+}
+
+// findEntry returns the entry of the given ID
+func findEntry(entries []*Entry, id string) *Entry {
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// failedEntries reports the entries as failed with the given error
+func failedEntries(entries []*Entry, err error) []Failure {
+	var (
+		code        = constants.GenericPublishError
+		senderFault = true
+	)
+	if awsErr, ok := err.(awserr.Error); ok {
+		code = awsErr.Code()
+		senderFault = !retry.IsRetryable(err)
+	}
+
+	failures := make([]Failure, 0, len(entries))
+	for _, entry := range entries {
+		failures = append(failures, Failure{ID: entry.ID, Code: code, Message: err.Error(), SenderFault: senderFault})
+	}
+	return failures
+}
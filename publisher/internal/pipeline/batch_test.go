@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/creatorstack/htsqs/publisher/retry"
+	"github.com/stretchr/testify/require"
+)
+
+func resultIDs(result *models.BatchResult) []string {
+	ids := make([]string, 0, len(result.Results))
+	for _, msgResult := range result.Results {
+		ids = append(ids, msgResult.ID)
+	}
+	return ids
+}
+
+// build builds the entries of the messages with the default settings
+func build(ctx context.Context, msg models.Message) (*Entry, error) {
+	return Build(ctx, newConfig(), msg, nil)
+}
+
+func TestPublishBatchSizeSplitting(t *testing.T) {
+	sender := &senderMock{}
+	cfg := &Config{ContinueOnError: true}
+
+	large := jsonString(`"` + strings.Repeat("a", 100*1024) + `"`)
+	msgs := make([]models.Message, 0, 6)
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, models.Message{ID: strconv.Itoa(i), Data: large})
+	}
+	msgs = append(msgs, models.Message{ID: "oversized", Data: jsonString(`"` + strings.Repeat("a", constants.MaxPayloadSize) + `"`)})
+
+	result, err := PublishBatch(context.TODO(), cfg, msgs, build, sender.send)
+	require.NoError(t, err)
+	require.Equal(t, 5, result.SuccessCount())
+	require.Len(t, sender.batches, 3)
+	require.Len(t, sender.batches[0], 2)
+	require.Len(t, sender.batches[1], 2)
+	require.Len(t, sender.batches[2], 1)
+
+	rejected := result.RejectedLocally()
+	require.Len(t, rejected, 1)
+	require.Equal(t, "oversized", rejected[0].ID)
+	var tooLarge *constants.MessageTooLargeError
+	require.True(t, errors.As(rejected[0].Err, &tooLarge))
+	require.Equal(t, constants.MaxPayloadSize, tooLarge.MaxSize)
+	require.Empty(t, result.RejectedByAWS())
+
+	cfg.ContinueOnError = false
+	_, err = PublishBatch(context.TODO(), cfg, msgs, build, sender.send)
+	require.True(t, errors.As(err, &tooLarge))
+	require.Len(t, sender.batches, 3)
+}
+
+func TestPublishBatchEntryIDs(t *testing.T) {
+	sender := &senderMock{}
+	cfg := &Config{ContinueOnError: true}
+
+	testString := jsonString(`{"msg":"message"}`)
+	msgs := []models.Message{
+		{ID: "order-1", Data: testString},
+		{Data: testString},
+		{ID: "order.2", Data: testString},
+		{ID: "order-1", Data: testString},
+		{Data: testString},
+	}
+	result, err := PublishBatch(context.TODO(), cfg, msgs, build, sender.send)
+	require.NoError(t, err)
+	require.Equal(t, 3, result.SuccessCount())
+
+	// messages without ID are published with a generated entry ID, mapped back in the result
+	entries := sender.batches[0]
+	require.Len(t, entries, 3)
+	require.Equal(t, "order-1", entries[0].ID)
+	require.Equal(t, "order-1", result.Results[0].EntryID)
+	require.Empty(t, result.Results[1].ID)
+	require.Equal(t, entries[1].ID, result.Results[1].EntryID)
+	require.Equal(t, entries[2].ID, result.Results[4].EntryID)
+	require.NotEqual(t, result.Results[1].EntryID, result.Results[4].EntryID)
+
+	require.Equal(t, constants.ErrInvalidBatchEntryID, result.Results[2].Err)
+	require.Equal(t, constants.ErrDuplicateBatchEntryID, result.Results[3].Err)
+	require.Equal(t, "order-1", result.Results[3].ID)
+	require.Len(t, result.RejectedLocally(), 2)
+
+	cfg.ContinueOnError = false
+	_, err = PublishBatch(context.TODO(), cfg, msgs, build, sender.send)
+	require.Equal(t, constants.ErrInvalidBatchEntryID, err)
+	require.Len(t, sender.batches, 1)
+}
+
+func TestPublishBatchRetry(t *testing.T) {
+	sender := &senderMock{
+		errs:            []error{awserr.New(request.ErrCodeRequestError, "connection reset", nil)},
+		failedEntries:   map[string]int{"2": 1, "3": 5},
+		rejectedEntries: map[string]bool{"4": true},
+	}
+	cfg := &Config{RetryPolicy: retry.Policy{MaxAttempts: 4, MinDelay: time.Millisecond}}
+
+	msgs := []models.Message{
+		{ID: "1", Data: jsonString(`{"key":"val1"}`)},
+		{ID: "2", Data: jsonString(`{"key":"val2"}`)},
+		{ID: "3", Data: jsonString(`{"key":"val3"}`)},
+		{ID: "4", Data: jsonString(`{"key":"val4"}`)},
+	}
+	result, err := PublishBatch(context.TODO(), cfg, msgs, build, sender.send)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.SuccessCount())
+	require.Equal(t, 2, result.ErrorCount())
+	require.Equal(t, []string{"1", "2", "3", "4"}, resultIDs(result))
+	require.NoError(t, result.Results[0].Err)
+	require.NoError(t, result.Results[1].Err)
+	require.EqualError(t, result.Results[2].Err, "internal error")
+	require.Equal(t, "InternalError", result.Results[2].Code)
+	require.False(t, result.Results[2].SenderFault)
+	require.EqualError(t, result.Results[3].Err, "invalid parameter")
+	require.True(t, result.Results[3].SenderFault)
+
+	// the failed call is retried with every entry, then only the entries failing with a transient error
+	require.Len(t, sender.batches, 4)
+	require.Len(t, sender.batches[1], 4)
+	require.Len(t, sender.batches[2], 2)
+	require.Len(t, sender.batches[3], 1)
+	require.Equal(t, "3", sender.batches[3][0].ID)
+}
+
+func TestPublishBatchContinueOnError(t *testing.T) {
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+	sender := &senderMock{errs: []error{throttled}}
+	cfg := &Config{ContinueOnError: true}
+
+	msgs := make([]models.Message, 0, 13)
+	for i := 0; i < cap(msgs); i++ {
+		msgs = append(msgs, models.Message{ID: strconv.Itoa(i), Data: jsonString(fmt.Sprintf(`{"key":"val%d"}`, i))})
+	}
+	msgs[11].GroupID = "group"
+
+	result, err := PublishBatch(context.TODO(), cfg, msgs, build, sender.send)
+	require.NoError(t, err)
+	require.Len(t, result.Results, len(msgs))
+	require.Equal(t, 2, result.SuccessCount())
+
+	// the first batch fails as a whole, the invalid message of the second one locally
+	for _, msgResult := range result.Results[:10] {
+		require.Equal(t, throttled, msgResult.Err)
+		require.Equal(t, "Throttling", msgResult.Code)
+		require.False(t, msgResult.SenderFault)
+	}
+	require.Equal(t, constants.ErrFifoOnlyField, result.Results[11].Err)
+	require.True(t, result.Results[11].SenderFault)
+	require.Equal(t, []models.MessageResult{result.Results[11]}, result.RejectedLocally())
+	require.Equal(t, []string{"10", "12"}, resultIDs(&models.BatchResult{Results: result.Successful()}))
+
+	// invalid messages are rejected before any message is published
+	cfg.ContinueOnError = false
+	batchCount := len(sender.batches)
+	result, err = PublishBatch(context.TODO(), cfg, msgs, build, sender.send)
+	require.Equal(t, constants.ErrFifoOnlyField, err)
+	require.Empty(t, result.Results)
+	require.Len(t, sender.batches, batchCount)
+
+	sender.errs = []error{throttled}
+	result, err = PublishBatch(context.TODO(), cfg, msgs[:11], build, sender.send)
+	require.Equal(t, throttled, err)
+	require.Empty(t, result.Results)
+
+	// publishing stops as well once the failed call has been retried
+	cfg.RetryPolicy = retry.Policy{MaxAttempts: 2, MinDelay: time.Millisecond}
+	sender.errs = []error{throttled, throttled}
+	batchCount = len(sender.batches)
+	result, err = PublishBatch(context.TODO(), cfg, msgs[:11], build, sender.send)
+	require.Equal(t, throttled, err)
+	require.Empty(t, result.Results)
+	require.Len(t, sender.batches, batchCount+2)
+}
@@ -0,0 +1,4 @@
+// Package pipeline builds and sends the messages of the SNS and SQS publishers: marshalling, compression,
+// payload offloading and batch packing and retries are shared, the publishers only adapting entries and
+// responses to their AWS types.
+package pipeline
@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/creatorstack/htsqs/blobstore"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/compression"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/creatorstack/htsqs/publisher/retry"
+)
+
+// Config holds the publisher settings messages are built and sent with
+type Config struct {
+
+	// Fifo is set when messages are sent to a FIFO topic or queue
+	Fifo bool
+
+	// The remaining fields are documented in the Config of the SNS and SQS publishers
+	MessageGroupIDFunc         models.KeyFunc
+	MessageDeduplicationIDFunc models.KeyFunc
+
+	Codec                codec.Codec
+	Compressor           compression.Compressor
+	CompressionThreshold int
+	PayloadStore         blobstore.Store
+	PayloadThreshold     int
+
+	ContinueOnError bool
+	RetryPolicy     retry.Policy
+}
+
+// Entry is a message ready to be sent, independent of the AWS service it is sent to
+type Entry struct {
+	ID              string
+	Body            string
+	GroupID         *string
+	DeduplicationID *string
+	Attributes      models.Attributes
+
+	// DelaySeconds is only supported by AWS SQS
+	DelaySeconds *int64
+}
+
+// Size returns the size in bytes of the entry, message attributes included
+func (e *Entry) Size() int {
+	return len(e.Body) + e.Attributes.Size()
+}
+
+// Build builds the entry the message is sent with. The reserved attributes are added by the publisher
+// to the attributes of the message, after these are validated
+func Build(ctx context.Context, cfg *Config, msg models.Message, reserved models.Attributes) (*Entry, error) {
+	b, err := cfg.Codec.Marshal(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	groupID, deduplicationID, err := fifoParams(cfg, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateAttributes(msg.Attributes); err != nil {
+		return nil, err
+	}
+
+	attrs := msg.Attributes
+	for name, attr := range reserved {
+		attrs = attrs.With(name, attr)
+	}
+
+	b, attrs, err = compress(cfg, b, attrs.With(constants.ContentTypeAttribute, models.StringAttribute(cfg.Codec.ContentType())))
+	if err != nil {
+		return nil, err
+	}
+	b, attrs = encodeBody(b, attrs)
+
+	// the attributes of the message share the AWS limit with the ones reserved by htsqs
+	offloaded := shouldOffload(cfg, b, attrs)
+	reservedCount := len(attrs) - len(msg.Attributes)
+	if offloaded {
+		reservedCount++
+	}
+	if len(msg.Attributes)+reservedCount > constants.MaxMessageAttributes {
+		return nil, fmt.Errorf("%w: the message has %d attributes, at most %d are allowed along with the %d reserved by htsqs",
+			constants.ErrTooManyAttributes, len(msg.Attributes), constants.MaxMessageAttributes-reservedCount, reservedCount)
+	}
+
+	body := b
+	if offloaded {
+		if body, attrs, err = offload(ctx, cfg, b, attrs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := attrs.Validate(); err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{
+		ID:              msg.ID,
+		Body:            string(body),
+		GroupID:         groupID,
+		DeduplicationID: deduplicationID,
+		Attributes:      attrs,
+	}
+
+	if size := entry.Size(); size > constants.MaxPayloadSize {
+		return nil, &constants.MessageTooLargeError{ID: msg.ID, Size: size, MaxSize: constants.MaxPayloadSize}
+	}
+	return entry, nil
+}
+
+// validateAttributes checks the attributes of the message comply with the AWS limits and do not use
+// the prefix reserved by htsqs
+func validateAttributes(attrs models.Attributes) error {
+	if err := attrs.Validate(); err != nil {
+		return err
+	}
+	for name := range attrs {
+		if strings.HasPrefix(strings.ToLower(name), constants.ReservedAttributePrefix) {
+			return fmt.Errorf("%w: %q uses the prefix reserved by htsqs", constants.ErrInvalidAttributeName, name)
+		}
+	}
+	return nil
+}
+
+// fifoParams returns the message group ID and deduplication ID the message must be sent with.
+// Both are nil for standard topics and queues, which do not support them. The deduplication ID is nil
+// when none is supplied nor derived, leaving AWS to require it unless content-based deduplication is enabled
+func fifoParams(cfg *Config, msg models.Message) (*string, *string, error) {
+	if !cfg.Fifo {
+		if msg.GroupID != "" || msg.DeduplicationID != "" {
+			return nil, nil, constants.ErrFifoOnlyField
+		}
+		return nil, nil, nil
+	}
+
+	groupID := msg.GroupID
+	if groupID == "" && cfg.MessageGroupIDFunc != nil {
+		groupID = cfg.MessageGroupIDFunc(msg)
+	}
+	if groupID == "" {
+		groupID = constants.DefaultMessageGroupID
+	}
+
+	deduplicationID := msg.DeduplicationID
+	if deduplicationID == "" && cfg.MessageDeduplicationIDFunc != nil {
+		deduplicationID = cfg.MessageDeduplicationIDFunc(msg)
+	}
+	if deduplicationID == "" {
+		return &groupID, nil, nil
+	}
+
+	return &groupID, &deduplicationID, nil
+}
+
+// compress compresses payloads larger than the compression threshold, recording it in the content encoding attribute
+func compress(cfg *Config, payload []byte, attrs models.Attributes) ([]byte, models.Attributes, error) {
+	if cfg.Compressor == nil || len(payload) <= cfg.CompressionThreshold {
+		return payload, attrs, nil
+	}
+
+	compressed, err := cfg.Compressor.Compress(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return compressed, withContentEncoding(attrs, cfg.Compressor.Encoding()), nil
+}
+
+// encodeBody base64 encodes payloads that are not valid message text, recording it in the content encoding attribute
+func encodeBody(payload []byte, attrs models.Attributes) ([]byte, models.Attributes) {
+	if codec.IsText(payload) {
+		return payload, attrs
+	}
+	return []byte(base64.StdEncoding.EncodeToString(payload)), withContentEncoding(attrs, codec.Base64Encoding)
+}
+
+// withContentEncoding appends the encoding to the list of encodings applied to the payload
+func withContentEncoding(attrs models.Attributes, encoding string) models.Attributes {
+	if contentEncoding, ok := attrs[constants.ContentEncodingAttribute]; ok {
+		encoding = contentEncoding.StringValue + ", " + encoding
+	}
+	return attrs.With(constants.ContentEncodingAttribute, models.StringAttribute(encoding))
+}
+
+// shouldOffload reports whether the payload must be offloaded, the message being larger than the payload threshold
+func shouldOffload(cfg *Config, payload []byte, attrs models.Attributes) bool {
+	return cfg.PayloadStore != nil && len(payload)+attrs.Size() > cfg.PayloadThreshold
+}
+
+// offload writes the payload to the payload store, returning the pointer to publish instead along with
+// the attributes flagging it
+func offload(ctx context.Context, cfg *Config, payload []byte, attrs models.Attributes) ([]byte, models.Attributes, error) {
+	pointer, err := blobstore.Offload(ctx, cfg.PayloadStore, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pointer, attrs.With(constants.OffloadedPayloadAttribute, models.IntAttribute(int64(len(payload)))), nil
+}
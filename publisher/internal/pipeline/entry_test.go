@@ -0,0 +1,175 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/creatorstack/htsqs/blobstore"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/compression"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/stretchr/testify/require"
+)
+
+type jsonString string
+
+func (js jsonString) MarshalJSON() ([]byte, error) {
+	return []byte(js), nil
+}
+
+// newConfig returns the configuration of a publisher created with the default settings
+func newConfig() *Config {
+	return &Config{
+		Codec:                codec.JSON,
+		CompressionThreshold: constants.DefaultCompressionThreshold,
+		PayloadThreshold:     constants.MaxPayloadSize,
+	}
+}
+
+func TestBuild(t *testing.T) {
+	msg := models.NewMessage(jsonString(`{"msg":"message"}`), models.WithAttribute("event-type", models.StringAttribute("created")))
+	msg.ID = "1"
+
+	entry, err := Build(context.TODO(), newConfig(), msg, models.Attributes{"reserved": models.IntAttribute(1)})
+	require.NoError(t, err)
+	require.Equal(t, &Entry{
+		ID:   "1",
+		Body: `{"msg":"message"}`,
+		Attributes: models.Attributes{
+			"event-type":                   models.StringAttribute("created"),
+			"reserved":                     models.IntAttribute(1),
+			constants.ContentTypeAttribute: models.StringAttribute(codec.JSONContentType),
+		},
+	}, entry)
+
+	_, err = Build(context.TODO(), newConfig(), models.NewMessage(jsonString(`{}`), models.WithAttribute("AWS.reserved", models.StringAttribute("value"))), nil)
+	require.ErrorIs(t, err, constants.ErrInvalidAttributeName)
+
+	_, err = Build(context.TODO(), newConfig(), models.Message{ID: "oversized", Data: jsonString(`"` + strings.Repeat("a", constants.MaxPayloadSize) + `"`)}, nil)
+	var tooLarge *constants.MessageTooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	require.Equal(t, "oversized", tooLarge.ID)
+}
+
+func TestBuildAttributeLimit(t *testing.T) {
+	attrs := make(models.Attributes)
+	for i := 0; i < constants.MaxMessageAttributes-1; i++ {
+		attrs[fmt.Sprintf("attr-%d", i)] = models.IntAttribute(int64(i))
+	}
+
+	// the content type attribute leaves room for 9 attributes
+	entry, err := Build(context.TODO(), newConfig(), models.NewMessage(jsonString(`{}`), models.WithAttributes(attrs)), nil)
+	require.NoError(t, err)
+	require.Len(t, entry.Attributes, constants.MaxMessageAttributes)
+
+	_, err = Build(context.TODO(), newConfig(), models.NewMessage(jsonString(`{}`), models.WithAttributes(attrs), models.WithAttribute("extra", models.IntAttribute(1))), nil)
+	require.ErrorIs(t, err, constants.ErrTooManyAttributes)
+
+	// and for 8 once the payload is offloaded, checked before the payload is stored
+	dir := t.TempDir()
+	cfg := newConfig()
+	cfg.PayloadStore = blobstore.NewFileStore(dir)
+	cfg.PayloadThreshold = 1
+	_, err = Build(context.TODO(), cfg, models.NewMessage(jsonString(`{}`), models.WithAttributes(attrs)), nil)
+	require.EqualError(t, err, "a message can have at most 10 message attributes: the message has 9 attributes, at most 8 are allowed along with the 2 reserved by htsqs")
+	stored, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, stored)
+
+	// the attributes reserved by htsqs cannot be set by the messages
+	_, err = Build(context.TODO(), newConfig(), models.NewMessage(jsonString(`{}`), models.WithAttribute(constants.DeliverAtAttribute, models.IntAttribute(0))), nil)
+	require.ErrorIs(t, err, constants.ErrInvalidAttributeName)
+	_, err = Build(context.TODO(), newConfig(), models.NewMessage(jsonString(`{}`), models.WithAttribute("HTSQS.custom", models.StringAttribute("value"))), nil)
+	require.ErrorIs(t, err, constants.ErrInvalidAttributeName)
+}
+
+func TestBuildFifo(t *testing.T) {
+	cfg := newConfig()
+	cfg.Fifo = true
+	cfg.MessageGroupIDFunc = func(msg models.Message) string { return "derived" }
+
+	entry, err := Build(context.TODO(), cfg, models.NewMessage(jsonString(`{}`)), nil)
+	require.NoError(t, err)
+	require.Equal(t, "derived", *entry.GroupID)
+	require.Nil(t, entry.DeduplicationID)
+
+	entry, err = Build(context.TODO(), cfg, models.NewMessage(jsonString(`{}`), models.WithGroupID("group"), models.WithDeduplicationID("dedup")), nil)
+	require.NoError(t, err)
+	require.Equal(t, "group", *entry.GroupID)
+	require.Equal(t, "dedup", *entry.DeduplicationID)
+
+	cfg.MessageGroupIDFunc = nil
+	cfg.MessageDeduplicationIDFunc = func(msg models.Message) string { return "derived" }
+	entry, err = Build(context.TODO(), cfg, models.NewMessage(jsonString(`{}`)), nil)
+	require.NoError(t, err)
+	require.Equal(t, constants.DefaultMessageGroupID, *entry.GroupID)
+	require.Equal(t, "derived", *entry.DeduplicationID)
+
+	_, err = Build(context.TODO(), newConfig(), models.NewMessage(jsonString(`{}`), models.WithGroupID("group")), nil)
+	require.Equal(t, constants.ErrFifoOnlyField, err)
+}
+
+func TestBuildOffload(t *testing.T) {
+	store := blobstore.NewFileStore(t.TempDir())
+	cfg := newConfig()
+	cfg.PayloadStore = store
+	cfg.PayloadThreshold = 60
+
+	entry, err := Build(context.TODO(), cfg, models.NewMessage(jsonString(`{"msg":"small"}`)), nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"msg":"small"}`, entry.Body)
+
+	entry, err = Build(context.TODO(), cfg, models.NewMessage(jsonString(`{"msg":"large message"}`)), nil)
+	require.NoError(t, err)
+	require.Equal(t, "23", entry.Attributes[constants.OffloadedPayloadAttribute].StringValue)
+
+	pointer, err := blobstore.ParsePointer([]byte(entry.Body))
+	require.NoError(t, err)
+	payload, err := store.Get(context.TODO(), pointer.Key)
+	require.NoError(t, err)
+	require.Equal(t, `{"msg":"large message"}`, string(payload))
+}
+
+func TestBuildCodec(t *testing.T) {
+	cfg := newConfig()
+	cfg.Codec = codec.MessagePack
+
+	entry, err := Build(context.TODO(), cfg, models.NewMessage(map[string]string{"msg": "message"}), nil)
+	require.NoError(t, err)
+	require.Equal(t, codec.MessagePackContentType, entry.Attributes[constants.ContentTypeAttribute].StringValue)
+	require.Equal(t, codec.Base64Encoding, entry.Attributes[constants.ContentEncodingAttribute].StringValue)
+
+	payload, err := base64.StdEncoding.DecodeString(entry.Body)
+	require.NoError(t, err)
+	var decoded map[string]string
+	require.NoError(t, codec.MessagePack.Unmarshal(payload, &decoded))
+	require.Equal(t, map[string]string{"msg": "message"}, decoded)
+}
+
+func TestBuildCompression(t *testing.T) {
+	cfg := newConfig()
+	cfg.Compressor = compression.Zstd
+	cfg.CompressionThreshold = 100
+
+	entry, err := Build(context.TODO(), cfg, models.NewMessage(jsonString(`{"msg":"small"}`)), nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"msg":"small"}`, entry.Body)
+	require.NotContains(t, entry.Attributes, constants.ContentEncodingAttribute)
+
+	largeString := strings.Repeat("repetitive ", 100)
+	entry, err = Build(context.TODO(), cfg, models.NewMessage(largeString), nil)
+	require.NoError(t, err)
+	require.Equal(t, compression.Zstd.Encoding()+", "+codec.Base64Encoding, entry.Attributes[constants.ContentEncodingAttribute].StringValue)
+
+	compressed, err := base64.StdEncoding.DecodeString(entry.Body)
+	require.NoError(t, err)
+	payload, err := compression.Zstd.Decompress(compressed)
+	require.NoError(t, err)
+	require.Equal(t, `"`+largeString+`"`, string(payload))
+}
@@ -0,0 +1,42 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+)
+
+type senderMock struct {
+	batches [][]*Entry
+
+	// errs are returned by the next calls, one per call
+	errs []error
+
+	// failedEntries holds the number of times each entry ID fails with a transient error
+	failedEntries map[string]int
+
+	// rejectedEntries holds the entry IDs always failing because of the sender
+	rejectedEntries map[string]bool
+}
+
+func (s *senderMock) send(ctx context.Context, entries []*Entry) (*Output, error) {
+	s.batches = append(s.batches, entries)
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, err
+	}
+
+	output := &Output{}
+	for _, entry := range entries {
+		switch {
+		case s.rejectedEntries[entry.ID]:
+			output.Failed = append(output.Failed, Failure{ID: entry.ID, Code: "InvalidParameter", Message: "invalid parameter", SenderFault: true})
+		case s.failedEntries[entry.ID] > 0:
+			s.failedEntries[entry.ID]--
+			output.Failed = append(output.Failed, Failure{ID: entry.ID, Code: "InternalError", Message: "internal error"})
+		default:
+			output.Successful = append(output.Successful, Success{ID: entry.ID, MessageID: fmt.Sprintf("message-%d-%s", len(s.batches), entry.ID)})
+		}
+	}
+	return output, nil
+}
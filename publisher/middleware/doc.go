@@ -0,0 +1,4 @@
+// Package middleware provides a publisher wrapping the SNS and SQS publishers with a chain of middlewares
+// intercepting their Publish, PublishWithResult and PublishBatch calls, along with built-in middlewares for logging,
+// timing and panic safety.
+package middleware
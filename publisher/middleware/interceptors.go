@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/creatorstack/htsqs/publisher/models"
+)
+
+// Operation names the publisher call intercepted by a middleware
+type Operation string
+
+const (
+	// PublishOperation is the Publish call
+	PublishOperation Operation = "Publish"
+
+	// PublishWithResultOperation is the PublishWithResult call
+	PublishWithResultOperation Operation = "PublishWithResult"
+
+	// PublishBatchOperation is the PublishBatch call
+	PublishBatchOperation Operation = "PublishBatch"
+)
+
+// ErrPanic is wrapped by the errors Recover returns when the publisher panics
+var ErrPanic = errors.New("publisher panicked")
+
+// Logger interface allows to use other loggers than standard log.Logger
+type Logger interface {
+	Printf(string, ...interface{})
+}
+
+// Logging logs the outcome of every publish call
+func Logging(logger Logger) Middleware {
+	return Middleware{
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, msg interface{}) error {
+				err := next(ctx, msg)
+				if err != nil {
+					logger.Printf("Failed to publish message: %v\n", err)
+				} else {
+					logger.Printf("Published message\n")
+				}
+				return err
+			}
+		},
+		PublishWithResult: func(next PublishWithResultFunc) PublishWithResultFunc {
+			return func(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error) {
+				result, err := next(ctx, msg, opts...)
+				if err != nil {
+					logger.Printf("Failed to publish message: %v\n", err)
+				} else {
+					logger.Printf("Published message %s\n", result.MessageID)
+				}
+				return result, err
+			}
+		},
+		PublishBatch: func(next PublishBatchFunc) PublishBatchFunc {
+			return func(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+				result, err := next(ctx, msgs)
+				if err != nil {
+					logger.Printf("Failed to publish batch of %d messages: %v\n", len(msgs), err)
+				} else {
+					logger.Printf("Published batch of %d messages: %d succeeded, %d failed\n",
+						len(msgs), result.SuccessCount(), result.ErrorCount())
+				}
+				return result, err
+			}
+		},
+	}
+}
+
+// Timing reports the duration and error of every publish call to the observe function,
+// e.g. to record latency metrics
+func Timing(observe func(op Operation, duration time.Duration, err error)) Middleware {
+	return Middleware{
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, msg interface{}) error {
+				start := time.Now()
+				err := next(ctx, msg)
+				observe(PublishOperation, time.Since(start), err)
+				return err
+			}
+		},
+		PublishWithResult: func(next PublishWithResultFunc) PublishWithResultFunc {
+			return func(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error) {
+				start := time.Now()
+				result, err := next(ctx, msg, opts...)
+				observe(PublishWithResultOperation, time.Since(start), err)
+				return result, err
+			}
+		},
+		PublishBatch: func(next PublishBatchFunc) PublishBatchFunc {
+			return func(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+				start := time.Now()
+				result, err := next(ctx, msgs)
+				observe(PublishBatchOperation, time.Since(start), err)
+				return result, err
+			}
+		},
+	}
+}
+
+// Recover turns the panics of the publish calls into errors wrapping ErrPanic, along with the panic stack trace
+func Recover() Middleware {
+	return Middleware{
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, msg interface{}) (err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						err = panicError(r)
+					}
+				}()
+				return next(ctx, msg)
+			}
+		},
+		PublishWithResult: func(next PublishWithResultFunc) PublishWithResultFunc {
+			return func(ctx context.Context, msg interface{}, opts ...models.PublishOption) (result models.PublishResult, err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						result, err = models.PublishResult{}, panicError(r)
+					}
+				}()
+				return next(ctx, msg, opts...)
+			}
+		},
+		PublishBatch: func(next PublishBatchFunc) PublishBatchFunc {
+			return func(ctx context.Context, msgs []models.Message) (result *models.BatchResult, err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						result, err = &models.BatchResult{}, panicError(r)
+					}
+				}()
+				return next(ctx, msgs)
+			}
+		},
+	}
+}
+
+func panicError(r interface{}) error {
+	return fmt.Errorf("%w: %v\n%s", ErrPanic, r, debug.Stack())
+}
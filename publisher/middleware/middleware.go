@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/creatorstack/htsqs/publisher"
+	"github.com/creatorstack/htsqs/publisher/models"
+)
+
+var (
+	// ErrBatchNotSupported is returned by PublishBatch when the wrapped publisher cannot publish batches
+	ErrBatchNotSupported = errors.New("publisher does not support batch publishing")
+
+	// ErrResultNotSupported is returned by PublishWithResult when the wrapped publisher does not return publish results
+	ErrResultNotSupported = errors.New("publisher does not support publish results")
+)
+
+// PublishFunc publishes a single message
+type PublishFunc func(ctx context.Context, msg interface{}) error
+
+// PublishWithResultFunc publishes a single message with options and returns the result of the publish
+type PublishWithResultFunc func(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error)
+
+// PublishBatchFunc publishes messages in batches
+type PublishBatchFunc func(ctx context.Context, msgs []models.Message) (*models.BatchResult, error)
+
+// Middleware intercepts the calls to a publisher. Any function can be nil to let the calls through
+type Middleware struct {
+
+	// Publish wraps the Publish calls
+	Publish func(next PublishFunc) PublishFunc
+
+	// PublishWithResult wraps the PublishWithResult calls
+	PublishWithResult func(next PublishWithResultFunc) PublishWithResultFunc
+
+	// PublishBatch wraps the PublishBatch calls
+	PublishBatch func(next PublishBatchFunc) PublishBatchFunc
+}
+
+// Publisher is a publisher whose calls go through a chain of middlewares before reaching the wrapped publisher
+type Publisher struct {
+	publish             PublishFunc
+	publishWithResult   PublishWithResultFunc
+	publishBatch        PublishBatchFunc
+	publishBatchPartial PublishBatchFunc
+}
+
+// Publish allows Publisher to implement the publisher.Publisher interface
+func (p *Publisher) Publish(ctx context.Context, msg interface{}) error {
+	return p.publish(ctx, msg)
+}
+
+// PublishWithResult allows Publisher to implement the publisher.ResultPublisher interface.
+// It returns ErrResultNotSupported when the wrapped publisher does not return publish results
+func (p *Publisher) PublishWithResult(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error) {
+	return p.publishWithResult(ctx, msg, opts...)
+}
+
+// PublishBatch allows Publisher to implement the publisher.BatchPublisher interface.
+// It returns ErrBatchNotSupported when the wrapped publisher cannot publish batches
+func (p *Publisher) PublishBatch(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	return p.publishBatch(ctx, msgs)
+}
+
+// PublishBatchPartial allows Publisher to implement the publisher.PartialBatchPublisher interface. The calls go
+// through the PublishBatch middlewares. When the wrapped publisher cannot publish batches partially, it behaves
+// like PublishBatch
+func (p *Publisher) PublishBatchPartial(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	return p.publishBatchPartial(ctx, msgs)
+}
+
+// unsupportedResult is the PublishWithResult function of the publishers that do not return publish results
+func unsupportedResult(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error) {
+	return models.PublishResult{}, ErrResultNotSupported
+}
+
+// unsupportedBatch is the PublishBatch function of the publishers that cannot publish batches
+func unsupportedBatch(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	return &models.BatchResult{}, ErrBatchNotSupported
+}
+
+// New wraps the publisher with the middlewares. The first middleware is the outermost one,
+// intercepting the calls before the others
+func New(pub publisher.Publisher, mws ...Middleware) *Publisher {
+	p := &Publisher{publish: pub.Publish, publishWithResult: unsupportedResult, publishBatch: unsupportedBatch}
+	if resultPub, ok := pub.(publisher.ResultPublisher); ok {
+		p.publishWithResult = resultPub.PublishWithResult
+	}
+	if batchPub, ok := pub.(publisher.BatchPublisher); ok {
+		p.publishBatch = batchPub.PublishBatch
+	}
+	p.publishBatchPartial = p.publishBatch
+	if partialPub, ok := pub.(publisher.PartialBatchPublisher); ok {
+		p.publishBatchPartial = partialPub.PublishBatchPartial
+	}
+
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i].Publish != nil {
+			p.publish = mws[i].Publish(p.publish)
+		}
+		if mws[i].PublishWithResult != nil {
+			p.publishWithResult = mws[i].PublishWithResult(p.publishWithResult)
+		}
+		if mws[i].PublishBatch != nil {
+			p.publishBatch = mws[i].PublishBatch(p.publishBatch)
+			p.publishBatchPartial = mws[i].PublishBatch(p.publishBatchPartial)
+		}
+	}
+	return p
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/stretchr/testify/require"
+)
+
+func tracing(name string, calls *[]string) Middleware {
+	return Middleware{
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, msg interface{}) error {
+				*calls = append(*calls, name)
+				return next(ctx, msg)
+			}
+		},
+		PublishWithResult: func(next PublishWithResultFunc) PublishWithResultFunc {
+			return func(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error) {
+				*calls = append(*calls, name)
+				return next(ctx, msg, append(opts, models.WithAttribute(name, models.StringAttribute("true")))...)
+			}
+		},
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var calls []string
+	mock := &batchPublisherMock{}
+	pub := New(mock, tracing("outer", &calls), Middleware{}, tracing("inner", &calls))
+
+	require.NoError(t, pub.Publish(context.TODO(), "message"))
+	require.Equal(t, []string{"outer", "inner"}, calls)
+	require.Equal(t, []interface{}{"message"}, mock.msgs)
+
+	// the options of the message go through the chain along with the ones added by the middlewares
+	calls = nil
+	result, err := pub.PublishWithResult(context.TODO(), "message", models.WithGroupID("group"))
+	require.NoError(t, err)
+	require.Equal(t, "message-1", result.MessageID)
+	require.Equal(t, []string{"outer", "inner"}, calls)
+	require.Equal(t, "group", mock.results[0].GroupID)
+	require.Equal(t, models.Attributes{
+		"outer": models.StringAttribute("true"),
+		"inner": models.StringAttribute("true"),
+	}, mock.results[0].Attributes)
+
+	// middlewares without batch interceptor let the calls through
+	batchResult, err := pub.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: "message"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, batchResult.SuccessCount())
+	require.Len(t, mock.batches, 1)
+}
+
+func TestMiddlewarePartialBatch(t *testing.T) {
+	var calls []string
+	tracingBatch := Middleware{
+		PublishBatch: func(next PublishBatchFunc) PublishBatchFunc {
+			return func(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+				calls = append(calls, "batch")
+				return next(ctx, msgs)
+			}
+		},
+	}
+
+	// partial batches go through the batch middlewares to the partial batch publisher
+	mock := &partialBatchPublisherMock{}
+	pub := New(mock, tracingBatch)
+	_, err := pub.PublishBatchPartial(context.TODO(), []models.Message{{ID: "1", Data: "message"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"batch"}, calls)
+	require.Len(t, mock.partialBatches, 1)
+
+	// and are published as whole batches when it cannot publish them partially
+	batchMock := &batchPublisherMock{}
+	_, err = New(batchMock, tracingBatch).PublishBatchPartial(context.TODO(), []models.Message{{ID: "1", Data: "message"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"batch", "batch"}, calls)
+	require.Len(t, batchMock.batches, 1)
+}
+
+func TestMiddlewareNotSupported(t *testing.T) {
+	pub := New(&publisherMock{})
+	_, err := pub.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: "message"}})
+	require.Equal(t, ErrBatchNotSupported, err)
+	_, err = pub.PublishWithResult(context.TODO(), "message")
+	require.Equal(t, ErrResultNotSupported, err)
+}
+
+func TestLogging(t *testing.T) {
+	logger := &loggerMock{}
+	mock := &batchPublisherMock{}
+	pub := New(mock, Logging(logger))
+
+	require.NoError(t, pub.Publish(context.TODO(), "message"))
+	_, err := pub.PublishWithResult(context.TODO(), "message")
+	require.NoError(t, err)
+	_, err = pub.PublishBatch(context.TODO(), []models.Message{{ID: "1"}, {ID: "2"}})
+	require.NoError(t, err)
+
+	mock.err = errors.New("publish error")
+	require.Error(t, pub.Publish(context.TODO(), "message"))
+
+	require.Equal(t, []string{
+		"Published message\n",
+		"Published message message-1\n",
+		"Published batch of 2 messages: 2 succeeded, 0 failed\n",
+		"Failed to publish message: publish error\n",
+	}, logger.lines)
+}
+
+func TestTiming(t *testing.T) {
+	var (
+		ops  []Operation
+		errs []error
+	)
+	mock := &batchPublisherMock{}
+	pub := New(mock, Timing(func(op Operation, duration time.Duration, err error) {
+		require.True(t, duration >= 0)
+		ops = append(ops, op)
+		errs = append(errs, err)
+	}))
+
+	require.NoError(t, pub.Publish(context.TODO(), "message"))
+	_, err := pub.PublishWithResult(context.TODO(), "message")
+	require.NoError(t, err)
+	mock.err = errors.New("publish error")
+	_, err = pub.PublishBatch(context.TODO(), []models.Message{{ID: "1"}})
+	require.Error(t, err)
+
+	require.Equal(t, []Operation{PublishOperation, PublishWithResultOperation, PublishBatchOperation}, ops)
+	require.Equal(t, []error{nil, nil, mock.err}, errs)
+}
+
+func TestRecover(t *testing.T) {
+	pub := New(&batchPublisherMock{}, Recover())
+
+	err := pub.Publish(context.TODO(), nil)
+	require.True(t, errors.Is(err, ErrPanic))
+	require.Contains(t, err.Error(), "nil message")
+
+	_, err = pub.PublishWithResult(context.TODO(), nil)
+	require.True(t, errors.Is(err, ErrPanic))
+
+	result, err := pub.PublishBatch(context.TODO(), nil)
+	require.True(t, errors.Is(err, ErrPanic))
+	require.NotNil(t, result)
+}
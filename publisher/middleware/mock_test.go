@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/creatorstack/htsqs/publisher/models"
+)
+
+type publisherMock struct {
+	msgs []interface{}
+	err  error
+}
+
+func (p *publisherMock) Publish(ctx context.Context, msg interface{}) error {
+	if msg == nil {
+		panic("nil message")
+	}
+	p.msgs = append(p.msgs, msg)
+	return p.err
+}
+
+type batchPublisherMock struct {
+	publisherMock
+	batches [][]models.Message
+	results []models.Message
+}
+
+func (p *batchPublisherMock) PublishWithResult(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error) {
+	if msg == nil {
+		panic("nil message")
+	}
+	p.results = append(p.results, models.NewMessage(msg, opts...))
+	return models.PublishResult{MessageID: fmt.Sprintf("message-%d", len(p.results))}, p.err
+}
+
+func (p *batchPublisherMock) PublishBatch(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	if msgs == nil {
+		panic("nil messages")
+	}
+	p.batches = append(p.batches, msgs)
+
+	result := &models.BatchResult{}
+	for _, msg := range msgs {
+		result.Results = append(result.Results, models.MessageResult{ID: msg.ID, EntryID: msg.ID})
+	}
+	return result, p.err
+}
+
+type loggerMock struct {
+	lines []string
+}
+
+func (l *loggerMock) Printf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+// partialBatchPublisherMock is a batchPublisherMock implementing publisher.PartialBatchPublisher
+type partialBatchPublisherMock struct {
+	batchPublisherMock
+	partialBatches [][]models.Message
+}
+
+func (p *partialBatchPublisherMock) PublishBatchPartial(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	p.partialBatches = append(p.partialBatches, msgs)
+	return p.PublishBatch(ctx, msgs)
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/creatorstack/htsqs/constants"
+)
+
+// Data types of the message attributes supported by AWS SNS and AWS SQS.
+// Custom data types can be built appending a label to them, e.g. "Number.float"
+const (
+	StringDataType      = "String"
+	NumberDataType      = "Number"
+	BinaryDataType      = "Binary"
+	StringArrayDataType = "String.Array"
+)
+
+var attributeNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-.]{1,256}$`)
+
+// Attribute is a typed message attribute. Attributes are delivered along with the message
+// and can be used by AWS SNS subscription filter policies and by the message consumers
+type Attribute struct {
+	DataType    string
+	StringValue string
+	BinaryValue []byte
+}
+
+// StringAttribute creates a String attribute
+func StringAttribute(value string) Attribute {
+	return Attribute{DataType: StringDataType, StringValue: value}
+}
+
+// NumberAttribute creates a Number attribute
+func NumberAttribute(value float64) Attribute {
+	return Attribute{DataType: NumberDataType, StringValue: strconv.FormatFloat(value, 'f', -1, 64)}
+}
+
+// IntAttribute creates a Number attribute holding an integer
+func IntAttribute(value int64) Attribute {
+	return Attribute{DataType: NumberDataType, StringValue: strconv.FormatInt(value, 10)}
+}
+
+// BinaryAttribute creates a Binary attribute
+func BinaryAttribute(value []byte) Attribute {
+	return Attribute{DataType: BinaryDataType, BinaryValue: value}
+}
+
+// StringArrayAttribute creates a String.Array attribute. AWS SQS handles it as a custom String attribute
+// holding the JSON encoded array
+func StringArrayAttribute(values []string) Attribute {
+	if values == nil {
+		values = []string{}
+	}
+	b, _ := json.Marshal(values)
+	return Attribute{DataType: StringArrayDataType, StringValue: string(b)}
+}
+
+// Validate checks the attribute value matches its data type
+func (a Attribute) Validate() error {
+	switch baseDataType(a.DataType) {
+	case StringDataType:
+		if a.StringValue == "" {
+			return fmt.Errorf("%w: empty %s value", constants.ErrInvalidAttributeValue, a.DataType)
+		}
+		if a.DataType == StringArrayDataType {
+			var values []interface{}
+			if err := json.Unmarshal([]byte(a.StringValue), &values); err != nil {
+				return fmt.Errorf("%w: %s value is not a JSON array", constants.ErrInvalidAttributeValue, a.DataType)
+			}
+		}
+	case NumberDataType:
+		if _, err := strconv.ParseFloat(a.StringValue, 64); err != nil {
+			return fmt.Errorf("%w: %q is not a number", constants.ErrInvalidAttributeValue, a.StringValue)
+		}
+	case BinaryDataType:
+		if len(a.BinaryValue) == 0 {
+			return fmt.Errorf("%w: empty %s value", constants.ErrInvalidAttributeValue, a.DataType)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported data type %q", constants.ErrInvalidAttributeValue, a.DataType)
+	}
+	return nil
+}
+
+// Attributes holds the message attributes by name
+type Attributes map[string]Attribute
+
+// Validate checks the attributes comply with the AWS limits: at most 10 attributes per message, with names
+// of up to 256 alphanumeric, hyphen, underscore or period characters, not starting nor ending with a period,
+// without consecutive periods and not using the reserved "AWS." and "Amazon." prefixes
+func (a Attributes) Validate() error {
+	if len(a) > constants.MaxMessageAttributes {
+		return constants.ErrTooManyAttributes
+	}
+
+	for name, attr := range a {
+		if err := validateAttributeName(name); err != nil {
+			return err
+		}
+		if err := attr.Validate(); err != nil {
+			return fmt.Errorf("attribute %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func validateAttributeName(name string) error {
+	lowerName := strings.ToLower(name)
+	if !attributeNameRegexp.MatchString(name) ||
+		strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") ||
+		strings.HasPrefix(lowerName, "aws.") || strings.HasPrefix(lowerName, "amazon.") {
+		return fmt.Errorf("%w: %q", constants.ErrInvalidAttributeName, name)
+	}
+	return nil
+}
+
+// baseDataType returns the data type without its custom label
+func baseDataType(dataType string) string {
+	if idx := strings.Index(dataType, "."); idx >= 0 {
+		return dataType[:idx]
+	}
+	return dataType
+}
+
+// With returns a copy of the attributes with the given attribute added
+func (a Attributes) With(name string, attr Attribute) Attributes {
+	attrs := make(Attributes, len(a)+1)
+	for n, at := range a {
+		attrs[n] = at
+	}
+	attrs[name] = attr
+	return attrs
+}
+
+// Size returns the number of bytes the attributes add to the message size: the name, data type
+// and value of every attribute
+func (a Attributes) Size() int {
+	size := 0
+	for name, attr := range a {
+		size += len(name) + len(attr.DataType) + len(attr.StringValue) + len(attr.BinaryValue)
+	}
+	return size
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttributesValidate(t *testing.T) {
+	tt := []struct {
+		name        string
+		attributes  Attributes
+		expectedErr error
+	}{
+		{
+			"Valid attributes",
+			Attributes{
+				"event-type":  StringAttribute("created"),
+				"retry.count": IntAttribute(3),
+				"price":       NumberAttribute(9.99),
+				"signature":   BinaryAttribute([]byte{0x1}),
+				"tags":        StringArrayAttribute([]string{"a", "b"}),
+				"custom_type": {DataType: "Number.float", StringValue: "1.5"},
+			},
+			nil,
+		},
+		{
+			"Too many attributes",
+			Attributes{
+				"a1": StringAttribute("v"), "a2": StringAttribute("v"), "a3": StringAttribute("v"),
+				"a4": StringAttribute("v"), "a5": StringAttribute("v"), "a6": StringAttribute("v"),
+				"a7": StringAttribute("v"), "a8": StringAttribute("v"), "a9": StringAttribute("v"),
+				"a10": StringAttribute("v"), "a11": StringAttribute("v"),
+			},
+			constants.ErrTooManyAttributes,
+		},
+		{"Invalid characters", Attributes{"event type": StringAttribute("v")}, constants.ErrInvalidAttributeName},
+		{"Leading period", Attributes{".event": StringAttribute("v")}, constants.ErrInvalidAttributeName},
+		{"Trailing period", Attributes{"event.": StringAttribute("v")}, constants.ErrInvalidAttributeName},
+		{"Consecutive periods", Attributes{"event..type": StringAttribute("v")}, constants.ErrInvalidAttributeName},
+		{"Reserved AWS prefix", Attributes{"aws.event": StringAttribute("v")}, constants.ErrInvalidAttributeName},
+		{"Reserved Amazon prefix", Attributes{"Amazon.event": StringAttribute("v")}, constants.ErrInvalidAttributeName},
+		{"Name too long", Attributes{strings.Repeat("a", 257): StringAttribute("v")}, constants.ErrInvalidAttributeName},
+		{"Empty string", Attributes{"event": StringAttribute("")}, constants.ErrInvalidAttributeValue},
+		{"Invalid number", Attributes{"count": {DataType: NumberDataType, StringValue: "one"}}, constants.ErrInvalidAttributeValue},
+		{"Empty binary", Attributes{"signature": BinaryAttribute(nil)}, constants.ErrInvalidAttributeValue},
+		{"Invalid string array", Attributes{"tags": {DataType: StringArrayDataType, StringValue: "a,b"}}, constants.ErrInvalidAttributeValue},
+		{"Unsupported data type", Attributes{"event": {DataType: "Date", StringValue: "today"}}, constants.ErrInvalidAttributeValue},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.attributes.Validate()
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestNewMessageWithAttributes(t *testing.T) {
+	msg := NewMessage("data",
+		WithAttribute("event-type", StringAttribute("created")),
+		WithAttributes(Attributes{"count": IntAttribute(1)}),
+	)
+	require.Equal(t, Attributes{"event-type": StringAttribute("created"), "count": IntAttribute(1)}, msg.Attributes)
+}
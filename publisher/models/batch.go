@@ -0,0 +1,38 @@
+package models
+
+import (
+	"regexp"
+
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/google/uuid"
+)
+
+// batchEntryIDRegexp matches the batch entry IDs allowed by AWS
+var batchEntryIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+
+// EntryIDs returns the batch entry ID each message is published with: its ID, or a generated one when empty.
+// The returned errors hold, for each message, whether its ID is not allowed by AWS or duplicates the ID of a
+// previous message of the batch
+func EntryIDs(msgs []Message) ([]string, []error) {
+	var (
+		ids  = make([]string, len(msgs))
+		errs = make([]error, len(msgs))
+		seen = make(map[string]bool, len(msgs))
+	)
+	for idx, msg := range msgs {
+		id := msg.ID
+		if id == "" {
+			id = uuid.New().String()
+		}
+		ids[idx] = id
+
+		switch {
+		case !batchEntryIDRegexp.MatchString(id):
+			errs[idx] = constants.ErrInvalidBatchEntryID
+		case seen[id]:
+			errs[idx] = constants.ErrDuplicateBatchEntryID
+		}
+		seen[id] = true
+	}
+	return ids, errs
+}
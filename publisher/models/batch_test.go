@@ -0,0 +1,35 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryIDs(t *testing.T) {
+	msgs := []Message{
+		{ID: "order-1_created"},
+		{},
+		{ID: "order.2"},
+		{ID: strings.Repeat("a", 81)},
+		{ID: "order-1_created"},
+		{},
+	}
+
+	ids, errs := EntryIDs(msgs)
+	require.Equal(t, "order-1_created", ids[0])
+	require.NoError(t, errs[0])
+
+	_, err := uuid.Parse(ids[1])
+	require.NoError(t, err)
+	require.NoError(t, errs[1])
+	require.NotEqual(t, ids[1], ids[5])
+	require.NoError(t, errs[5])
+
+	require.Equal(t, constants.ErrInvalidBatchEntryID, errs[2])
+	require.Equal(t, constants.ErrInvalidBatchEntryID, errs[3])
+	require.Equal(t, constants.ErrDuplicateBatchEntryID, errs[4])
+}
@@ -1,6 +1,103 @@
 package models
 
+import "time"
+
 type Message struct {
 	ID   string      `json:"id"`
 	Data interface{} `json:"data"`
+
+	// GroupID is the message group the message belongs to. Only supported by FIFO queues and topics
+	GroupID string `json:"-"`
+
+	// DeduplicationID is the token used for deduplication of sent messages.
+	// Only supported by FIFO queues and topics
+	DeduplicationID string `json:"-"`
+
+	// Attributes are the message attributes sent along with the message. They share the AWS limit of
+	// 10 attributes with the ones reserved by htsqs, and cannot use the "htsqs." prefix
+	// (see constants.ReservedAttributePrefix)
+	Attributes Attributes `json:"-"`
+
+	// Delay is the time the message is hidden from consumers after being sent.
+	// Only supported by AWS SQS standard queues
+	Delay time.Duration `json:"-"`
+
+	// DeliverAt is the time the message is hidden from consumers until, taking precedence over Delay.
+	// Only supported by AWS SQS standard queues
+	DeliverAt time.Time `json:"-"`
+}
+
+// Delayed reports whether the delivery of the message is delayed, by either Delay or DeliverAt
+func (m Message) Delayed() bool {
+	return m.Delay != 0 || !m.DeliverAt.IsZero()
+}
+
+// DeliveryTime returns the time the message must be delivered at when sent at the given time
+func (m Message) DeliveryTime(now time.Time) time.Time {
+	if !m.DeliverAt.IsZero() {
+		return m.DeliverAt
+	}
+	return now.Add(m.Delay)
+}
+
+// KeyFunc derives a key, such as the message group ID, from a message
+type KeyFunc func(msg Message) string
+
+// PublishOption configures a single message published through a publisher
+type PublishOption func(*Message)
+
+// WithGroupID sets the message group ID of the published message
+func WithGroupID(groupID string) PublishOption {
+	return func(m *Message) {
+		m.GroupID = groupID
+	}
+}
+
+// WithDeduplicationID sets the deduplication ID of the published message
+func WithDeduplicationID(deduplicationID string) PublishOption {
+	return func(m *Message) {
+		m.DeduplicationID = deduplicationID
+	}
+}
+
+// WithDelay delays the delivery of the published message by the given duration
+func WithDelay(delay time.Duration) PublishOption {
+	return func(m *Message) {
+		m.Delay, m.DeliverAt = delay, time.Time{}
+	}
+}
+
+// WithDeliverAt delays the delivery of the published message until the given time
+func WithDeliverAt(deliverAt time.Time) PublishOption {
+	return func(m *Message) {
+		m.Delay, m.DeliverAt = 0, deliverAt
+	}
+}
+
+// WithAttribute adds the given attribute to the published message
+func WithAttribute(name string, attr Attribute) PublishOption {
+	return func(m *Message) {
+		if m.Attributes == nil {
+			m.Attributes = make(Attributes)
+		}
+		m.Attributes[name] = attr
+	}
+}
+
+// WithAttributes adds the given attributes to the published message
+func WithAttributes(attrs Attributes) PublishOption {
+	return func(m *Message) {
+		for name, attr := range attrs {
+			WithAttribute(name, attr)(m)
+		}
+	}
+}
+
+// NewMessage creates a new message holding the given data with the options applied
+func NewMessage(data interface{}, opts ...PublishOption) Message {
+	msg := Message{Data: data}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return msg
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageDeliveryTime(t *testing.T) {
+	now := time.Now()
+	deliverAt := now.Add(time.Hour)
+
+	require.False(t, NewMessage("data").Delayed())
+	require.Equal(t, now, NewMessage("data").DeliveryTime(now))
+
+	msg := NewMessage("data", WithDelay(time.Minute))
+	require.True(t, msg.Delayed())
+	require.Equal(t, now.Add(time.Minute), msg.DeliveryTime(now))
+
+	// the delivery time is kept as given rather than converted to a delay when the message is built
+	msg = NewMessage("data", WithDelay(time.Minute), WithDeliverAt(deliverAt))
+	require.True(t, msg.Delayed())
+	require.Equal(t, deliverAt, msg.DeliveryTime(now.Add(time.Minute)))
+
+	// the last option applied wins
+	msg = NewMessage("data", WithDeliverAt(deliverAt), WithDelay(time.Minute))
+	require.Equal(t, now.Add(time.Minute), msg.DeliveryTime(now))
+}
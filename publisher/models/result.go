@@ -0,0 +1,129 @@
+package models
+
+// PublishResult holds the IDs assigned by AWS to a published message
+type PublishResult struct {
+
+	// MessageID is the ID assigned by AWS to the published message
+	MessageID string
+
+	// SequenceNumber is the sequence number assigned by AWS to messages published to a FIFO queue or topic
+	SequenceNumber string
+}
+
+// MessageResult is the outcome of publishing a message of a batch
+type MessageResult struct {
+
+	// ID of the message supplied by the caller, empty when the message was published without ID
+	ID string
+
+	// EntryID is the ID of the message in the batch sent to AWS: its ID, or a generated one when empty
+	EntryID string
+
+	// MessageID is the ID assigned by AWS to the published message
+	MessageID string
+
+	// SequenceNumber is the sequence number assigned by AWS to messages published to a FIFO queue or topic
+	SequenceNumber string
+
+	// Code is the error code of a failed message
+	Code string
+
+	// SenderFault reports whether the message failed because of the sender, e.g. an invalid message
+	SenderFault bool
+
+	// Local reports whether the message was rejected locally, before being sent to AWS
+	Local bool
+
+	// Err is the publish error of the message, nil when published successfully
+	Err error
+}
+
+// BatchResult lists the outcome of each message of a batch, in the order the messages were supplied
+type BatchResult struct {
+	Results []MessageResult
+}
+
+// Result returns the outcome of the message with the given ID
+func (r *BatchResult) Result(id string) (MessageResult, bool) {
+	for _, result := range r.Results {
+		if result.ID == id {
+			return result, true
+		}
+	}
+	return MessageResult{}, false
+}
+
+// Successful returns the outcome of the messages published successfully
+func (r *BatchResult) Successful() []MessageResult {
+	var successful []MessageResult
+	for _, result := range r.Results {
+		if result.Err == nil {
+			successful = append(successful, result)
+		}
+	}
+	return successful
+}
+
+// Failed returns the outcome of the messages that failed to be published
+func (r *BatchResult) Failed() []MessageResult {
+	var failed []MessageResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// RejectedLocally returns the outcome of the messages rejected before being sent to AWS,
+// e.g. messages that could not be marshalled or larger than allowed by AWS
+func (r *BatchResult) RejectedLocally() []MessageResult {
+	var rejected []MessageResult
+	for _, result := range r.Results {
+		if result.Err != nil && result.Local {
+			rejected = append(rejected, result)
+		}
+	}
+	return rejected
+}
+
+// RejectedByAWS returns the outcome of the messages sent to AWS that failed to be published
+func (r *BatchResult) RejectedByAWS() []MessageResult {
+	var rejected []MessageResult
+	for _, result := range r.Results {
+		if result.Err != nil && !result.Local {
+			rejected = append(rejected, result)
+		}
+	}
+	return rejected
+}
+
+// SuccessCount returns the number of messages published successfully
+func (r *BatchResult) SuccessCount() int {
+	return len(r.Results) - r.ErrorCount()
+}
+
+// ErrorCount returns the number of messages that failed to be published
+func (r *BatchResult) ErrorCount() int {
+	count := 0
+	for _, result := range r.Results {
+		if result.Err != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Errors returns the publish error of each message by ID, nil for the messages published successfully.
+// Messages published without ID are keyed by their generated entry ID
+func (r *BatchResult) Errors() map[string]error {
+	errs := make(map[string]error, len(r.Results))
+	for _, result := range r.Results {
+		id := result.ID
+		if id == "" {
+			id = result.EntryID
+		}
+		errs[id] = result.Err
+	}
+	return errs
+}
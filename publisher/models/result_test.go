@@ -0,0 +1,33 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchResult(t *testing.T) {
+	failure := errors.New("failed")
+	result := &BatchResult{Results: []MessageResult{
+		{ID: "1", MessageID: "message-1"},
+		{ID: "2", Code: "InternalError", Err: failure},
+		{ID: "3", MessageID: "message-3", SequenceNumber: "10"},
+		{ID: "4", SenderFault: true, Local: true, Err: failure},
+	}}
+
+	require.Equal(t, 2, result.SuccessCount())
+	require.Equal(t, 2, result.ErrorCount())
+	require.Equal(t, []MessageResult{result.Results[0], result.Results[2]}, result.Successful())
+	require.Equal(t, []MessageResult{result.Results[1], result.Results[3]}, result.Failed())
+	require.Equal(t, []MessageResult{result.Results[3]}, result.RejectedLocally())
+	require.Equal(t, []MessageResult{result.Results[1]}, result.RejectedByAWS())
+	require.Equal(t, map[string]error{"1": nil, "2": failure, "3": nil, "4": failure}, result.Errors())
+
+	msgResult, ok := result.Result("3")
+	require.True(t, ok)
+	require.Equal(t, "10", msgResult.SequenceNumber)
+
+	_, ok = result.Result("5")
+	require.False(t, ok)
+}
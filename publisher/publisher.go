@@ -2,9 +2,27 @@ package publisher
 
 import (
 	"context"
+
+	"github.com/creatorstack/htsqs/publisher/models"
 )
 
 // Publisher is the interface clients can use to publish messages
 type Publisher interface {
 	Publish(ctx context.Context, msg interface{}) error
 }
+
+// ResultPublisher is the interface of the publishers returning the IDs assigned by AWS to the published messages
+type ResultPublisher interface {
+	PublishWithResult(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error)
+}
+
+// BatchPublisher is the interface of the publishers able to publish several messages at once
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, msgs []models.Message) (*models.BatchResult, error)
+}
+
+// PartialBatchPublisher is the interface of the batch publishers able to keep publishing the remaining messages
+// of a batch when some of them fail, reporting the error of each failed message in its result only
+type PartialBatchPublisher interface {
+	PublishBatchPartial(ctx context.Context, msgs []models.Message) (*models.BatchResult, error)
+}
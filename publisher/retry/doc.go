@@ -0,0 +1,3 @@
+// Package retry provides the retry policy publishers use to retry throttled and transient AWS errors
+// with exponential backoff and jitter, both for whole API calls and for the failed entries of a batch.
+package retry
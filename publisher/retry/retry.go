@@ -0,0 +1,136 @@
+package retry
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/jpillora/backoff"
+)
+
+const (
+	// defaultMinDelay is the delay before the first retry
+	defaultMinDelay = 100 * time.Millisecond
+
+	// defaultMaxDelay is the maximum delay between retries
+	defaultMaxDelay = 10 * time.Second
+
+	// defaultFactor is the multiplying factor of the delay on each retry
+	defaultFactor = 2
+)
+
+// Policy configures the retries of the publisher calls. The zero value disables retries
+type Policy struct {
+
+	// maximum number of attempts, the first one included. Retries are disabled when lower than 2
+	MaxAttempts int
+
+	// delay before the first retry. Defaults to 100 milliseconds
+	MinDelay time.Duration
+
+	// maximum delay between retries. Defaults to 10 seconds
+	MaxDelay time.Duration
+
+	// multiplying factor of the delay on each retry. Defaults to 2
+	Factor float64
+
+	// Retryable decides whether an error is worth retrying. Defaults to IsRetryable
+	Retryable func(error) bool
+}
+
+// Backoff returns the exponential backoff with jitter between attempts
+func (p Policy) Backoff() *backoff.Backoff {
+	b := &backoff.Backoff{Min: p.MinDelay, Max: p.MaxDelay, Factor: p.Factor, Jitter: true}
+	if b.Min <= 0 {
+		b.Min = defaultMinDelay
+	}
+	if b.Max <= 0 {
+		b.Max = defaultMaxDelay
+	}
+	if b.Factor <= 0 {
+		b.Factor = defaultFactor
+	}
+	return b
+}
+
+// CanRetry reports whether attempts remain after the given attempt, starting at 1
+func (p Policy) CanRetry(attempt int) bool {
+	return attempt < p.MaxAttempts
+}
+
+// ShouldRetry reports whether the given attempt, starting at 1, failed with an error worth retrying
+// and attempts remain
+func (p Policy) ShouldRetry(attempt int, err error) bool {
+	if err == nil || !p.CanRetry(attempt) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// ShouldRetryEntry reports whether the given attempt, starting at 1, failed to publish a batch entry with
+// an error worth retrying and attempts remain. Entries failing because of the sender, e.g. an invalid
+// message, are only retried on throttling and transient errors
+func (p Policy) ShouldRetryEntry(attempt int, code, message string, senderFault bool) bool {
+	if !senderFault {
+		return p.CanRetry(attempt)
+	}
+	return p.ShouldRetry(attempt, awserr.New(code, message, nil))
+}
+
+// Do calls fn until it succeeds, fails with an error not worth retrying, the attempts are exhausted
+// or the context is done
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	b := p.Backoff()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !p.ShouldRetry(attempt, err) {
+			return err
+		}
+		if sleepErr := Sleep(ctx, b.Duration()); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// Sleep pauses for the given duration or until the context is done, returning the context error
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// transientCodes are the AWS error codes of transient failures
+var transientCodes = map[string]bool{
+	request.ErrCodeRequestError:    true,
+	request.ErrCodeResponseTimeout: true,
+	"RequestTimeout":               true,
+	"RequestTimeoutException":      true,
+	"InternalError":                true,
+	"InternalFailure":              true,
+	"ServiceUnavailable":           true,
+	"KMSThrottlingException":       true,
+}
+
+// IsRetryable reports whether the error is a throttling or a transient AWS error
+func IsRetryable(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= http.StatusInternalServerError {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return transientCodes[awsErr.Code()]
+	}
+	return false
+}
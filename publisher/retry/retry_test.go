@@ -0,0 +1,101 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyDo(t *testing.T) {
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+	policy := Policy{MaxAttempts: 3, MinDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	attempts := 0
+	err := policy.Do(context.TODO(), func() error {
+		attempts++
+		if attempts < 3 {
+			return throttled
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = policy.Do(context.TODO(), func() error {
+		attempts++
+		return throttled
+	})
+	require.Equal(t, throttled, err)
+	require.Equal(t, 3, attempts)
+
+	attempts = 0
+	invalid := awserr.New("InvalidParameter", "Invalid parameter", nil)
+	err = policy.Do(context.TODO(), func() error {
+		attempts++
+		return invalid
+	})
+	require.Equal(t, invalid, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestPolicyDisabled(t *testing.T) {
+	attempts := 0
+	err := Policy{}.Do(context.TODO(), func() error {
+		attempts++
+		return awserr.New("Throttling", "Rate exceeded", nil)
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestPolicyContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	attempts := 0
+	err := Policy{MaxAttempts: 5, MinDelay: time.Hour}.Do(ctx, func() error {
+		attempts++
+		return awserr.New("Throttling", "Rate exceeded", nil)
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestPolicyCustomRetryable(t *testing.T) {
+	custom := errors.New("custom error")
+	policy := Policy{MaxAttempts: 2, Retryable: func(err error) bool { return err == custom }}
+	require.True(t, policy.ShouldRetry(1, custom))
+	require.False(t, policy.ShouldRetry(2, custom))
+	require.False(t, policy.ShouldRetry(1, errors.New("other error")))
+}
+
+func TestPolicyBackoffDefaults(t *testing.T) {
+	b := Policy{}.Backoff()
+	require.Equal(t, defaultMinDelay, b.Min)
+	require.Equal(t, defaultMaxDelay, b.Max)
+	require.Equal(t, float64(defaultFactor), b.Factor)
+	require.True(t, b.Jitter)
+}
+
+func TestIsRetryable(t *testing.T) {
+	require.True(t, IsRetryable(awserr.New("ThrottledException", "", nil)))
+	require.True(t, IsRetryable(awserr.New(request.ErrCodeRequestError, "", nil)))
+	require.True(t, IsRetryable(awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusInternalServerError, "")))
+	require.False(t, IsRetryable(awserr.NewRequestFailure(awserr.New("InvalidParameter", "", nil), http.StatusBadRequest, "")))
+	require.False(t, IsRetryable(errors.New("marshal error")))
+}
+
+func TestPolicyShouldRetryEntry(t *testing.T) {
+	policy := Policy{MaxAttempts: 2}
+	require.True(t, policy.ShouldRetryEntry(1, "InternalError", "", false))
+	require.True(t, policy.ShouldRetryEntry(1, "Throttling", "", true))
+	require.False(t, policy.ShouldRetryEntry(1, "InvalidParameter", "", true))
+	require.False(t, policy.ShouldRetryEntry(2, "InternalError", "", false))
+}
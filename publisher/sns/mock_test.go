@@ -2,23 +2,82 @@ package sns
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sns"
 )
 
 type snsPublisherMock struct {
-	queue chan<- *string
+	queue       chan<- *string
+	inputs      []*sns.PublishInput
+	batchInputs []*sns.PublishBatchInput
+
+	// errs are returned by the next calls, one per call
+	errs []error
+
+	// failedEntries holds the number of times each entry ID fails with a transient error
+	failedEntries map[string]int
+
+	// rejectedEntries holds the entry IDs always failing because of the sender
+	rejectedEntries map[string]bool
+}
+
+func (p *snsPublisherMock) nextErr() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	err := p.errs[0]
+	p.errs = p.errs[1:]
+	return err
 }
 
 func (p *snsPublisherMock) PublishWithContext(ctx context.Context, input *sns.PublishInput, o ...request.Option) (*sns.PublishOutput, error) {
+	p.inputs = append(p.inputs, input)
+	if err := p.nextErr(); err != nil {
+		return nil, err
+	}
 	p.queue <- input.Message
-	return &sns.PublishOutput{}, nil
+	return &sns.PublishOutput{MessageId: aws.String(messageID(len(p.inputs))), SequenceNumber: sequenceNumber(input.MessageGroupId)}, nil
 }
 
 func (p *snsPublisherMock) PublishBatchWithContext(ctx context.Context, input *sns.PublishBatchInput, o ...request.Option) (*sns.PublishBatchOutput, error) {
+	p.batchInputs = append(p.batchInputs, input)
+	if err := p.nextErr(); err != nil {
+		return nil, err
+	}
+
+	output := &sns.PublishBatchOutput{}
 	for _, entry := range input.PublishBatchRequestEntries {
-		p.queue <- entry.Message
+		id := aws.StringValue(entry.Id)
+		switch {
+		case p.rejectedEntries[id]:
+			output.Failed = append(output.Failed, &sns.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("InvalidParameter"), Message: aws.String("invalid parameter"), SenderFault: aws.Bool(true),
+			})
+		case p.failedEntries[id] > 0:
+			p.failedEntries[id]--
+			output.Failed = append(output.Failed, &sns.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("internal error"), SenderFault: aws.Bool(false),
+			})
+		default:
+			p.queue <- entry.Message
+			output.Successful = append(output.Successful, &sns.PublishBatchResultEntry{
+				Id: entry.Id, MessageId: aws.String(messageID(len(p.batchInputs))), SequenceNumber: sequenceNumber(entry.MessageGroupId),
+			})
+		}
+	}
+	return output, nil
+}
+
+func messageID(call int) string {
+	return fmt.Sprintf("message-%d", call)
+}
+
+func sequenceNumber(groupID *string) *string {
+	if groupID == nil {
+		return nil
 	}
-	return &sns.PublishBatchOutput{}, nil
+	return aws.String("10000000000000000000")
 }
@@ -2,16 +2,19 @@ package sns
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/creatorstack/htsqs/blobstore"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/compression"
 	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher/internal/pipeline"
 	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/creatorstack/htsqs/publisher/retry"
 )
 
 // sender is the interface to sns.SNS. Its sole purpose is to make
@@ -29,6 +32,48 @@ type Config struct {
 
 	// Topic ARN where the messages are going to be sent
 	TopicArn string
+
+	// MessageGroupIDFunc derives the message group ID of a message published to a FIFO topic
+	// when none is supplied. Messages without a group ID are published to the "default" group
+	MessageGroupIDFunc models.KeyFunc
+
+	// MessageDeduplicationIDFunc derives the deduplication ID of a message published to a FIFO topic
+	// when none is supplied. Messages without a deduplication ID are rejected by AWS unless the topic
+	// has content-based deduplication enabled
+	MessageDeduplicationIDFunc models.KeyFunc
+
+	// Codec marshals the published payloads. Defaults to JSON.
+	//
+	// The subscriber learns how a payload was encoded, compressed or offloaded from the htsqs message attributes.
+	// AWS SQS queues subscribed to the topic only receive them as message attributes when the subscription has
+	// raw message delivery enabled. Otherwise the payload and its attributes are wrapped in the AWS SNS JSON
+	// envelope and cannot be decoded. Codecs other than JSON, Compressor and PayloadStore require raw message
+	// delivery
+	Codec codec.Codec
+
+	// Compressor compresses the payloads larger than CompressionThreshold. Compression is disabled when nil.
+	// Requires raw message delivery, see Codec. Custom compressors must be registered on the subscriber side,
+	// see compression.Register
+	Compressor compression.Compressor
+
+	// CompressionThreshold is the payload size in bytes above which payloads are compressed. Defaults to 1 KB
+	CompressionThreshold int
+
+	// PayloadStore is the blob store payloads larger than PayloadThreshold are offloaded to,
+	// publishing a pointer to the stored payload instead. Requires raw message delivery, see Codec
+	PayloadStore blobstore.Store
+
+	// PayloadThreshold is the message size in bytes, attributes included, above which payloads are offloaded.
+	// Defaults to the maximum message size allowed by AWS
+	PayloadThreshold int
+
+	// ContinueOnError keeps publishing the remaining messages of PublishBatch when a message cannot be
+	// built or a batch fails to be published, recording the error in the result of the affected messages
+	ContinueOnError bool
+
+	// RetryPolicy retries throttled and transient publish errors, re-sending only the failed entries
+	// of a batch. Retries are disabled by default
+	RetryPolicy retry.Policy
 }
 
 // Publisher is the AWS SNS message publisher
@@ -40,120 +85,181 @@ type Publisher struct {
 // Publish allows SNS Publisher to implement the publisher.Publisher interface
 // and publish messages to an AWS SNS backend
 func (p *Publisher) Publish(ctx context.Context, msg interface{}) error {
-	b, err := json.Marshal(msg)
+	return p.PublishWithOptions(ctx, msg)
+}
 
-	defaultMessageGroupID := "default"
+// PublishWithOptions publishes a message to an AWS SNS backend applying the given options,
+// e.g. the message group ID and deduplication ID of messages sent to a FIFO topic
+func (p *Publisher) PublishWithOptions(ctx context.Context, msg interface{}, opts ...models.PublishOption) error {
+	_, err := p.PublishWithResult(ctx, msg, opts...)
+	return err
+}
 
+// PublishWithResult allows SNS Publisher to implement the publisher.ResultPublisher interface. It publishes
+// a message like PublishWithOptions and returns the message ID and sequence number assigned by AWS SNS
+func (p *Publisher) PublishWithResult(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error) {
+	entry, err := p.requestEntry(ctx, models.NewMessage(msg, opts...))
 	if err != nil {
-		return err
+		return models.PublishResult{}, err
 	}
 
 	input := &sns.PublishInput{
-		Message:  aws.String(string(b)),
-		TopicArn: &p.cfg.TopicArn,
+		Message:                aws.String(entry.Body),
+		MessageGroupId:         entry.GroupID,
+		MessageDeduplicationId: entry.DeduplicationID,
+		MessageAttributes:      messageAttributes(entry.Attributes),
+		TopicArn:               &p.cfg.TopicArn,
 	}
-	// if the topic is a fifo topic, we need to set the message group id
-	if strings.Contains(strings.ToLower(*input.TopicArn), "fifo") {
-		input.MessageGroupId = &defaultMessageGroupID
+
+	if err := input.Validate(); err != nil {
+		return models.PublishResult{}, err
 	}
 
-	_, err = p.sns.PublishWithContext(ctx, input)
+	var output *sns.PublishOutput
+	err = p.cfg.RetryPolicy.Do(ctx, func() (err error) {
+		output, err = p.sns.PublishWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return models.PublishResult{}, err
+	}
 
-	return err
+	return models.PublishResult{
+		MessageID:      aws.StringValue(output.MessageId),
+		SequenceNumber: aws.StringValue(output.SequenceNumber),
+	}, nil
 }
 
-// PublishBatch allows SNS Publisher to implement the publisher.Publisher interface
-// and publish messages in a single batch to an AWS SNS backend. Since AWS SNS batch
-// publish can only handle a maximum payload of 10 messages at a time, the messages
-// supplied will be published in batches of 10. For this reason, message sets are best
-// kept under 100 messages so that all messages can be published in 10 tries. In case
-// of failure when parsing or publishing any of the messages, this function will stop
-// further publishing and return an error
-func (p *Publisher) PublishBatch(ctx context.Context, msgs []models.Message) (map[string]error, int64, int64, error) {
-	var (
-		defaultMessageGroupID = "default"
-		publishResult         = make(map[string]error)
-		err                   error
-
-		errorCount   int64
-		successCount int64
-
-		numPublishedMessages = 0
-		start                = 0
-		end                  = constants.MaxBatchSize
-	)
-
-	isFifo := strings.Contains(strings.ToLower(p.cfg.TopicArn), "fifo")
-
-	if end > len(msgs) {
-		end = len(msgs)
-	}
-	for numPublishedMessages < len(msgs) {
-		var (
-			requestEntries = make([]*sns.PublishBatchRequestEntry, 0)
-		)
-		for idx := start; idx < end; idx++ {
-			msg := msgs[idx]
-
-			b, err := json.Marshal(msg.Data)
-			if err != nil {
-				return publishResult, successCount, errorCount, err
-			}
-
-			requestEntry := &sns.PublishBatchRequestEntry{
-				Id:      aws.String(msg.ID),
-				Message: aws.String(string(b)),
-			}
-
-			if isFifo {
-				requestEntry.MessageGroupId = &defaultMessageGroupID
-			}
-
-			requestEntries = append(requestEntries, requestEntry)
-		}
+// PublishBatch allows SNS Publisher to implement the publisher.BatchPublisher interface
+// and publish messages in batches to an AWS SNS backend. Since AWS SNS batch publish can
+// only handle a maximum of 10 messages and 256 KB at a time, the messages supplied will be
+// published in batches packed by both count and size. For this reason, message sets are best
+// kept under 100 messages so that all messages can be published in 10 tries. Messages sent
+// to a FIFO topic keep their GroupID and DeduplicationID. Failed messages are re-sent
+// according to the retry policy before being reported as failed. The returned result lists
+// the outcome of every processed message. Messages that cannot be parsed or are larger than
+// allowed by AWS are rejected before any message is published. In case of failure when parsing
+// or publishing any of the messages, this function will stop further publishing and return
+// an error, unless ContinueOnError is set
+func (p *Publisher) PublishBatch(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	return pipeline.PublishBatch(ctx, p.pipelineConfig(), msgs, p.requestEntry, p.sendBatch)
+}
+
+// PublishBatchPartial allows SNS Publisher to implement the publisher.PartialBatchPublisher interface.
+// It publishes the messages like PublishBatch with ContinueOnError set, whatever the configuration
+func (p *Publisher) PublishBatchPartial(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	cfg := p.pipelineConfig()
+	cfg.ContinueOnError = true
+	return pipeline.PublishBatch(ctx, cfg, msgs, p.requestEntry, p.sendBatch)
+}
+
+// sendBatch publishes the entries in a single AWS SNS PublishBatch request
+func (p *Publisher) sendBatch(ctx context.Context, entries []*pipeline.Entry) (*pipeline.Output, error) {
+	input := &sns.PublishBatchInput{
+		PublishBatchRequestEntries: make([]*sns.PublishBatchRequestEntry, 0, len(entries)),
+		TopicArn:                   &p.cfg.TopicArn,
+	}
+	for _, entry := range entries {
+		input.PublishBatchRequestEntries = append(input.PublishBatchRequestEntries, &sns.PublishBatchRequestEntry{
+			Id:                     aws.String(entry.ID),
+			Message:                aws.String(entry.Body),
+			MessageGroupId:         entry.GroupID,
+			MessageDeduplicationId: entry.DeduplicationID,
+			MessageAttributes:      messageAttributes(entry.Attributes),
+		})
+	}
+
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	response, err := p.sns.PublishBatchWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
 
-		input := &sns.PublishBatchInput{
-			PublishBatchRequestEntries: requestEntries,
-			TopicArn:                   &p.cfg.TopicArn,
+	output := &pipeline.Output{}
+	for _, errEntry := range response.Failed {
+		if errEntry != nil && errEntry.Id != nil {
+			output.Failed = append(output.Failed, pipeline.Failure{
+				ID:          *errEntry.Id,
+				Code:        aws.StringValue(errEntry.Code),
+				Message:     aws.StringValue(errEntry.Message),
+				SenderFault: aws.BoolValue(errEntry.SenderFault),
+			})
 		}
-		response, err := p.sns.PublishBatchWithContext(ctx, input)
-		if err != nil {
-			return publishResult, successCount, errorCount, err
+	}
+	for _, successEntry := range response.Successful {
+		if successEntry != nil && successEntry.Id != nil {
+			output.Successful = append(output.Successful, pipeline.Success{
+				ID:             *successEntry.Id,
+				MessageID:      aws.StringValue(successEntry.MessageId),
+				SequenceNumber: aws.StringValue(successEntry.SequenceNumber),
+			})
 		}
+	}
+	return output, nil
+}
 
-		for _, errEntry := range response.Failed {
-			if errEntry != nil && errEntry.Id != nil {
-				errMsg := constants.GenericPublishError
-				if errEntry.Message != nil {
-					errMsg = *errEntry.Message
-				}
-				publishResult[*errEntry.Id] = errors.New(errMsg)
-				errorCount++
-			}
-		}
+// requestEntry builds the entry the message is published with
+func (p *Publisher) requestEntry(ctx context.Context, msg models.Message) (*pipeline.Entry, error) {
+	if msg.Delayed() {
+		return nil, constants.ErrDelayNotSupported
+	}
+	return pipeline.Build(ctx, p.pipelineConfig(), msg, nil)
+}
 
-		for _, successEntry := range response.Successful {
-			if successEntry != nil && successEntry.Id != nil {
-				publishResult[*successEntry.Id] = nil
-				successCount++
-			}
-		}
+// pipelineConfig returns the settings messages are built and published with
+func (p *Publisher) pipelineConfig() *pipeline.Config {
+	return &pipeline.Config{
+		Fifo:                       strings.HasSuffix(p.cfg.TopicArn, constants.FifoSuffix),
+		MessageGroupIDFunc:         p.cfg.MessageGroupIDFunc,
+		MessageDeduplicationIDFunc: p.cfg.MessageDeduplicationIDFunc,
+		Codec:                      p.cfg.Codec,
+		Compressor:                 p.cfg.Compressor,
+		CompressionThreshold:       p.cfg.CompressionThreshold,
+		PayloadStore:               p.cfg.PayloadStore,
+		PayloadThreshold:           p.cfg.PayloadThreshold,
+		ContinueOnError:            p.cfg.ContinueOnError,
+		RetryPolicy:                p.cfg.RetryPolicy,
+	}
+}
 
-		numPublishedMessages += len(requestEntries)
-		start = end
-		end += constants.MaxBatchSize
-		if end > len(msgs) {
-			end = len(msgs)
-		}
+// messageAttributes converts the message attributes to their AWS SNS representation
+func messageAttributes(attrs models.Attributes) map[string]*sns.MessageAttributeValue {
+	if len(attrs) == 0 {
+		return nil
 	}
 
-	return publishResult, successCount, errorCount, err
+	messageAttributes := make(map[string]*sns.MessageAttributeValue, len(attrs))
+	for name, attr := range attrs {
+		value := &sns.MessageAttributeValue{DataType: aws.String(attr.DataType)}
+		if attr.BinaryValue != nil {
+			value.BinaryValue = attr.BinaryValue
+		} else {
+			value.StringValue = aws.String(attr.StringValue)
+		}
+		messageAttributes[name] = value
+	}
+	return messageAttributes
 }
 
 func defaultPublisherConfig(cfg *Config) {
 	if cfg.AWSSession == nil {
 		cfg.AWSSession = session.Must(session.NewSession())
 	}
+
+	if cfg.Codec == nil {
+		cfg.Codec = codec.JSON
+	}
+
+	if cfg.CompressionThreshold == 0 {
+		cfg.CompressionThreshold = constants.DefaultCompressionThreshold
+	}
+
+	if cfg.PayloadThreshold == 0 {
+		cfg.PayloadThreshold = constants.MaxPayloadSize
+	}
 }
 
 // New creates a new AWS SNS publisher
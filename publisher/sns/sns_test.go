@@ -3,9 +3,14 @@ package sns
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/constants"
 	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/creatorstack/htsqs/publisher/retry"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,6 +20,14 @@ func (js jsonString) MarshalJSON() ([]byte, error) {
 	return []byte(js), nil
 }
 
+func resultIDs(result *models.BatchResult) []string {
+	ids := make([]string, 0, len(result.Results))
+	for _, msgResult := range result.Results {
+		ids = append(ids, msgResult.ID)
+	}
+	return ids
+}
+
 func TestPublisher(t *testing.T) {
 	queue := make(chan *string, 1)
 	defer close(queue)
@@ -45,7 +58,7 @@ func TestPublisherBatch(t *testing.T) {
 	pubs := New(Config{})
 	pubs.sns = &snsPublisherMock{queue: queue}
 
-	_, _, _, err := pubs.PublishBatch(context.TODO(), inputs)
+	_, err := pubs.PublishBatch(context.TODO(), inputs)
 
 	require.NoError(t, err)
 
@@ -60,6 +73,166 @@ func TestPublisherBatch(t *testing.T) {
 	}
 }
 
+func TestPublisherFifo(t *testing.T) {
+	queue := make(chan *string, 3)
+	defer close(queue)
+	mock := &snsPublisherMock{queue: queue}
+	pubs := New(Config{
+		TopicArn:           "arn:aws:sns:us-east-1:123456789012:myTopic.fifo",
+		MessageGroupIDFunc: func(msg models.Message) string { return "derived" },
+	})
+	pubs.sns = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.Publish(context.TODO(), testString))
+	<-queue
+	require.Equal(t, "derived", *mock.inputs[0].MessageGroupId)
+	require.Nil(t, mock.inputs[0].MessageDeduplicationId)
+
+	require.NoError(t, pubs.PublishWithOptions(context.TODO(), testString, models.WithGroupID("group"), models.WithDeduplicationID("dedup")))
+	<-queue
+	require.Equal(t, "group", *mock.inputs[1].MessageGroupId)
+	require.Equal(t, "dedup", *mock.inputs[1].MessageDeduplicationId)
+
+	_, err := pubs.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: testString, GroupID: "batchGroup"}})
+	require.NoError(t, err)
+	<-queue
+	require.Equal(t, "batchGroup", *mock.batchInputs[0].PublishBatchRequestEntries[0].MessageGroupId)
+}
+
+func TestPublisherFifoDetection(t *testing.T) {
+	queue := make(chan *string, 1)
+	defer close(queue)
+	mock := &snsPublisherMock{queue: queue}
+	pubs := New(Config{TopicArn: "arn:aws:sns:us-east-1:123456789012:fifo-events"})
+	pubs.sns = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.Publish(context.TODO(), testString))
+	<-queue
+	require.Nil(t, mock.inputs[0].MessageGroupId)
+
+	require.Equal(t, constants.ErrFifoOnlyField, pubs.PublishWithOptions(context.TODO(), testString, models.WithGroupID("group")))
+
+	// publishing partially, only the offending message fails
+	result, err := pubs.PublishBatchPartial(context.TODO(), []models.Message{
+		{ID: "1", Data: testString},
+		{ID: "2", Data: testString, GroupID: "group"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, result.Results[0].Err)
+	require.Equal(t, constants.ErrFifoOnlyField, result.Results[1].Err)
+}
+
+func TestPublisherAttributes(t *testing.T) {
+	queue := make(chan *string, 2)
+	defer close(queue)
+	mock := &snsPublisherMock{queue: queue}
+	pubs := New(Config{})
+	pubs.sns = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.PublishWithOptions(context.TODO(), testString, models.WithAttribute("event-type", models.StringAttribute("created"))))
+	<-queue
+	require.Equal(t, "String", *mock.inputs[0].MessageAttributes["event-type"].DataType)
+	require.Equal(t, "created", *mock.inputs[0].MessageAttributes["event-type"].StringValue)
+
+	_, err := pubs.PublishBatch(context.TODO(), []models.Message{
+		{ID: "1", Data: testString, Attributes: models.Attributes{"signature": models.BinaryAttribute([]byte("sig"))}},
+	})
+	require.NoError(t, err)
+	<-queue
+	attr := mock.batchInputs[0].PublishBatchRequestEntries[0].MessageAttributes["signature"]
+	require.Equal(t, "Binary", *attr.DataType)
+	require.Equal(t, []byte("sig"), attr.BinaryValue)
+
+	err = pubs.PublishWithOptions(context.TODO(), testString, models.WithAttribute("AWS.reserved", models.StringAttribute("value")))
+	require.ErrorIs(t, err, constants.ErrInvalidAttributeName)
+}
+
+func TestPublisherDelayNotSupported(t *testing.T) {
+	queue := make(chan *string, 1)
+	defer close(queue)
+	pubs := New(Config{})
+	pubs.sns = &snsPublisherMock{queue: queue}
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.Equal(t, constants.ErrDelayNotSupported, pubs.PublishWithOptions(context.TODO(), testString, models.WithDelay(time.Minute)))
+	require.Equal(t, constants.ErrDelayNotSupported, pubs.PublishWithOptions(context.TODO(), testString, models.WithDeliverAt(time.Now().Add(time.Minute))))
+}
+
+func TestPublisherRetry(t *testing.T) {
+	queue := make(chan *string, 1)
+	defer close(queue)
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+	mock := &snsPublisherMock{queue: queue, errs: []error{throttled, throttled}}
+	pubs := New(Config{RetryPolicy: retry.Policy{MaxAttempts: 3, MinDelay: time.Millisecond}})
+	pubs.sns = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.Publish(context.TODO(), testString))
+	require.Equal(t, testString, jsonString(*<-queue))
+	require.Len(t, mock.inputs, 3)
+
+	mock.errs = []error{throttled, throttled, throttled}
+	require.Equal(t, throttled, pubs.Publish(context.TODO(), testString))
+	require.Len(t, mock.inputs, 6)
+}
+
+func TestPublisherBatchFailures(t *testing.T) {
+	queue := make(chan *string, 1)
+	defer close(queue)
+	mock := &snsPublisherMock{
+		queue:           queue,
+		failedEntries:   map[string]int{"2": 1},
+		rejectedEntries: map[string]bool{"3": true},
+	}
+	pubs := New(Config{})
+	pubs.sns = mock
+
+	msgs := []models.Message{
+		{ID: "1", Data: jsonString(`{"key":"val1"}`)},
+		{ID: "2", Data: jsonString(`{"key":"val2"}`)},
+		{ID: "3", Data: jsonString(`{"key":"val3"}`)},
+	}
+	result, err := pubs.PublishBatch(context.TODO(), msgs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2", "3"}, resultIDs(result))
+	require.NoError(t, result.Results[0].Err)
+	require.EqualError(t, result.Results[1].Err, "internal error")
+	require.Equal(t, "InternalError", result.Results[1].Code)
+	require.False(t, result.Results[1].SenderFault)
+	require.EqualError(t, result.Results[2].Err, "invalid parameter")
+	require.Equal(t, "InvalidParameter", result.Results[2].Code)
+	require.True(t, result.Results[2].SenderFault)
+	require.Len(t, mock.batchInputs, 1)
+}
+
+func TestPublisherResult(t *testing.T) {
+	queue := make(chan *string, 2)
+	defer close(queue)
+	pubs := New(Config{})
+	pubs.sns = &snsPublisherMock{queue: queue}
+
+	testString := jsonString(`{"msg":"message"}`)
+	result, err := pubs.PublishWithResult(context.TODO(), testString)
+	require.NoError(t, err)
+	<-queue
+	require.Equal(t, models.PublishResult{MessageID: "message-1"}, result)
+
+	pubs.cfg.TopicArn = "arn:aws:sns:us-east-1:123456789012:myTopic.fifo"
+	result, err = pubs.PublishWithResult(context.TODO(), testString, models.WithDeduplicationID("dedup"))
+	require.NoError(t, err)
+	<-queue
+	require.Equal(t, models.PublishResult{MessageID: "message-2", SequenceNumber: "10000000000000000000"}, result)
+
+	batchResult, err := pubs.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: testString, DeduplicationID: "dedup"}})
+	require.NoError(t, err)
+	<-queue
+	require.Equal(t, "message-1", batchResult.Results[0].MessageID)
+	require.Equal(t, "10000000000000000000", batchResult.Results[0].SequenceNumber)
+}
+
 func TestPublisherDefaults(t *testing.T) {
 
 	tt := []struct {
@@ -69,13 +242,13 @@ func TestPublisherDefaults(t *testing.T) {
 	}{
 		{
 			"Custom parameters",
-			Config{AWSSession: session.Must(session.NewSession()), TopicArn: "myTopicARN"},
-			Config{TopicArn: "myTopicARN"},
+			Config{AWSSession: session.Must(session.NewSession()), TopicArn: "myTopicARN", Codec: codec.MessagePack, CompressionThreshold: 2048, PayloadThreshold: 1024},
+			Config{TopicArn: "myTopicARN", Codec: codec.MessagePack, CompressionThreshold: 2048, PayloadThreshold: 1024},
 		},
 		{
 			"Use defaults parameters",
 			Config{},
-			Config{},
+			Config{Codec: codec.JSON, CompressionThreshold: constants.DefaultCompressionThreshold, PayloadThreshold: constants.MaxPayloadSize},
 		},
 	}
 
@@ -2,16 +2,99 @@ package sns
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
 type sqsPublisherMock struct {
-	queue chan<- *string
+	queue       chan<- *string
+	inputs      []*sqs.SendMessageInput
+	batchInputs []*sqs.SendMessageBatchInput
+
+	// errs are returned by the next calls, one per call
+	errs []error
+
+	// failedEntries holds the number of times each entry ID fails with a transient error
+	failedEntries map[string]int
+
+	// rejectedEntries holds the entry IDs always failing because of the sender
+	rejectedEntries map[string]bool
+
+	// corrupt makes the returned MD5 digests mismatch the message bodies sent
+	corrupt bool
+}
+
+func (p *sqsPublisherMock) nextErr() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	err := p.errs[0]
+	p.errs = p.errs[1:]
+	return err
 }
 
 func (p *sqsPublisherMock) SendMessageWithContext(ctx context.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	p.inputs = append(p.inputs, input)
+	if err := p.nextErr(); err != nil {
+		return nil, err
+	}
 	p.queue <- input.MessageBody
-	return &sqs.SendMessageOutput{}, nil
+	return &sqs.SendMessageOutput{
+		MessageId:        aws.String(fmt.Sprintf("message-%d", len(p.inputs))),
+		MD5OfMessageBody: p.md5(input.MessageBody),
+		SequenceNumber:   sequenceNumber(input.MessageGroupId),
+	}, nil
+}
+
+func (p *sqsPublisherMock) SendMessageBatchWithContext(ctx context.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	p.batchInputs = append(p.batchInputs, input)
+	if err := p.nextErr(); err != nil {
+		return nil, err
+	}
+
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		id := aws.StringValue(entry.Id)
+		switch {
+		case p.rejectedEntries[id]:
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("InvalidParameterValue"), Message: aws.String("invalid parameter value"), SenderFault: aws.Bool(true),
+			})
+		case p.failedEntries[id] > 0:
+			p.failedEntries[id]--
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("internal error"), SenderFault: aws.Bool(false),
+			})
+		default:
+			p.queue <- entry.MessageBody
+			output.Successful = append(output.Successful, &sqs.SendMessageBatchResultEntry{
+				Id:               entry.Id,
+				MessageId:        aws.String(fmt.Sprintf("message-%d-%s", len(p.batchInputs), id)),
+				MD5OfMessageBody: p.md5(entry.MessageBody),
+				SequenceNumber:   sequenceNumber(entry.MessageGroupId),
+			})
+		}
+	}
+	return output, nil
+}
+
+func (p *sqsPublisherMock) md5(body *string) *string {
+	data := aws.StringValue(body)
+	if p.corrupt {
+		data += "corrupted"
+	}
+	sum := md5.Sum([]byte(data))
+	return aws.String(hex.EncodeToString(sum[:]))
+}
+
+func sequenceNumber(groupID *string) *string {
+	if groupID == nil {
+		return nil
+	}
+	return aws.String("10000000000000000000")
 }
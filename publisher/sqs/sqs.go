@@ -2,18 +2,29 @@ package sns
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/creatorstack/htsqs/blobstore"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/compression"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher/internal/pipeline"
+	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/creatorstack/htsqs/publisher/retry"
 )
 
 // sender is the interface to sqs.SQS. Its sole purpose is to make
 // Publisher.service and interface that we can mock for testing.
 type sender interface {
 	SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error)
+	SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error)
 }
 
 // Config holds the info required to work with AWS SQS to publish a message
@@ -24,6 +35,41 @@ type Config struct {
 
 	// SQS queue where the publisher is going to push messages to
 	QueueURL string
+
+	// MessageGroupIDFunc derives the message group ID of a message published to a FIFO queue
+	// when none is supplied. Messages without a group ID are published to the "default" group
+	MessageGroupIDFunc models.KeyFunc
+
+	// MessageDeduplicationIDFunc derives the deduplication ID of a message published to a FIFO queue
+	// when none is supplied. Messages without a deduplication ID are rejected by AWS unless the queue
+	// has content-based deduplication enabled
+	MessageDeduplicationIDFunc models.KeyFunc
+
+	// Codec marshals the published payloads. Defaults to JSON
+	Codec codec.Codec
+
+	// Compressor compresses the payloads larger than CompressionThreshold. Compression is disabled when nil.
+	// Custom compressors must be registered on the subscriber side, see compression.Register
+	Compressor compression.Compressor
+
+	// CompressionThreshold is the payload size in bytes above which payloads are compressed. Defaults to 1 KB
+	CompressionThreshold int
+
+	// PayloadStore is the blob store payloads larger than PayloadThreshold are offloaded to,
+	// publishing a pointer to the stored payload instead
+	PayloadStore blobstore.Store
+
+	// PayloadThreshold is the message size in bytes, attributes included, above which payloads are offloaded.
+	// Defaults to the maximum message size allowed by AWS
+	PayloadThreshold int
+
+	// ContinueOnError keeps publishing the remaining messages of PublishBatch when a message cannot be
+	// built or a batch fails to be sent, recording the error in the result of the affected messages
+	ContinueOnError bool
+
+	// RetryPolicy retries throttled and transient send errors, re-sending only the failed entries
+	// of a batch. Retries are disabled by default
+	RetryPolicy retry.Policy
 }
 
 // Publisher is the AWS SNS message publisher
@@ -35,29 +81,236 @@ type Publisher struct {
 // Publish allows SQS Publisher to implement the publisher.Publisher interface
 // and publish messages to an AWS SQS backend
 func (p *Publisher) Publish(ctx context.Context, msg interface{}) error {
-	b, err := json.Marshal(msg)
+	return p.PublishWithOptions(ctx, msg)
+}
 
+// PublishWithOptions publishes a message to an AWS SQS backend applying the given options,
+// e.g. the message group ID and deduplication ID of messages sent to a FIFO queue or the
+// delivery delay of messages sent to a standard queue
+func (p *Publisher) PublishWithOptions(ctx context.Context, msg interface{}, opts ...models.PublishOption) error {
+	_, err := p.PublishWithResult(ctx, msg, opts...)
+	return err
+}
+
+// PublishWithResult allows SQS Publisher to implement the publisher.ResultPublisher interface. It publishes
+// a message like PublishWithOptions and returns the message ID and sequence number assigned by AWS SQS,
+// verifying the MD5 digest of the message body returned by AWS SQS against the message body sent
+func (p *Publisher) PublishWithResult(ctx context.Context, msg interface{}, opts ...models.PublishOption) (models.PublishResult, error) {
+	entry, err := p.requestEntry(ctx, models.NewMessage(msg, opts...))
 	if err != nil {
-		return err
+		return models.PublishResult{}, err
 	}
 
 	input := &sqs.SendMessageInput{
-		MessageBody: aws.String(string(b)),
-		QueueUrl:    &p.cfg.QueueURL,
+		DelaySeconds:           entry.DelaySeconds,
+		MessageBody:            aws.String(entry.Body),
+		MessageGroupId:         entry.GroupID,
+		MessageDeduplicationId: entry.DeduplicationID,
+		MessageAttributes:      messageAttributes(entry.Attributes),
+		QueueUrl:               &p.cfg.QueueURL,
 	}
 
 	if err := input.Validate(); err != nil {
+		return models.PublishResult{}, err
+	}
+
+	var output *sqs.SendMessageOutput
+	err = p.cfg.RetryPolicy.Do(ctx, func() (err error) {
+		output, err = p.sqs.SendMessageWithContext(ctx, input)
 		return err
+	})
+	if err != nil {
+		return models.PublishResult{}, err
 	}
-	_, err = p.sqs.SendMessageWithContext(ctx, input)
 
-	return err
+	result := models.PublishResult{
+		MessageID:      aws.StringValue(output.MessageId),
+		SequenceNumber: aws.StringValue(output.SequenceNumber),
+	}
+	return result, verifyMD5(*input.MessageBody, output.MD5OfMessageBody)
+}
+
+// PublishBatch publishes messages in batches to an AWS SQS backend. Messages sent to a FIFO queue
+// keep their GroupID and DeduplicationID, messages sent to a standard queue their Delay. Since AWS SQS
+// SendMessageBatch can only handle a maximum of 10 messages and 256 KB at a time, the messages
+// supplied will be published in batches packed by both count and size. Failed messages are re-sent
+// according to the retry policy before being reported as failed. The returned result lists the outcome
+// of every processed message. Messages that cannot be parsed or are larger than allowed by AWS are
+// rejected before any message is published. In case of failure when parsing or publishing any of the
+// messages, this function will stop further publishing and return an error, unless ContinueOnError is set
+func (p *Publisher) PublishBatch(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	return pipeline.PublishBatch(ctx, p.pipelineConfig(), msgs, p.requestEntry, p.sendBatch)
+}
+
+// PublishBatchPartial allows SQS Publisher to implement the publisher.PartialBatchPublisher interface.
+// It publishes the messages like PublishBatch with ContinueOnError set, whatever the configuration
+func (p *Publisher) PublishBatchPartial(ctx context.Context, msgs []models.Message) (*models.BatchResult, error) {
+	cfg := p.pipelineConfig()
+	cfg.ContinueOnError = true
+	return pipeline.PublishBatch(ctx, cfg, msgs, p.requestEntry, p.sendBatch)
+}
+
+// sendBatch sends the entries in a single AWS SQS SendMessageBatch request. Entries whose MD5 digest
+// returned by AWS does not match the message body sent are reported with an error
+func (p *Publisher) sendBatch(ctx context.Context, entries []*pipeline.Entry) (*pipeline.Output, error) {
+	input := &sqs.SendMessageBatchInput{
+		Entries:  make([]*sqs.SendMessageBatchRequestEntry, 0, len(entries)),
+		QueueUrl: &p.cfg.QueueURL,
+	}
+	bodies := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		input.Entries = append(input.Entries, &sqs.SendMessageBatchRequestEntry{
+			Id:                     aws.String(entry.ID),
+			DelaySeconds:           entry.DelaySeconds,
+			MessageBody:            aws.String(entry.Body),
+			MessageGroupId:         entry.GroupID,
+			MessageDeduplicationId: entry.DeduplicationID,
+			MessageAttributes:      messageAttributes(entry.Attributes),
+		})
+		bodies[entry.ID] = entry.Body
+	}
+
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	response, err := p.sqs.SendMessageBatchWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &pipeline.Output{}
+	for _, errEntry := range response.Failed {
+		if errEntry != nil && errEntry.Id != nil {
+			output.Failed = append(output.Failed, pipeline.Failure{
+				ID:          *errEntry.Id,
+				Code:        aws.StringValue(errEntry.Code),
+				Message:     aws.StringValue(errEntry.Message),
+				SenderFault: aws.BoolValue(errEntry.SenderFault),
+			})
+		}
+	}
+	for _, successEntry := range response.Successful {
+		if successEntry != nil && successEntry.Id != nil {
+			output.Successful = append(output.Successful, pipeline.Success{
+				ID:             *successEntry.Id,
+				MessageID:      aws.StringValue(successEntry.MessageId),
+				SequenceNumber: aws.StringValue(successEntry.SequenceNumber),
+				Err:            verifyMD5(bodies[*successEntry.Id], successEntry.MD5OfMessageBody),
+			})
+		}
+	}
+	return output, nil
+}
+
+// verifyMD5 checks the MD5 digest returned by AWS SQS against the message body sent.
+// The check is skipped when no digest was returned
+func verifyMD5(body string, digest *string) error {
+	if digest == nil {
+		return nil
+	}
+
+	sum := md5.Sum([]byte(body))
+	if hex.EncodeToString(sum[:]) != *digest {
+		return constants.ErrChecksumMismatch
+	}
+	return nil
+}
+
+// requestEntry builds the entry the message is sent with
+func (p *Publisher) requestEntry(ctx context.Context, msg models.Message) (*pipeline.Entry, error) {
+	cfg := p.pipelineConfig()
+
+	delaySeconds, reserved, err := p.delayParams(cfg, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := pipeline.Build(ctx, cfg, msg, reserved)
+	if err != nil {
+		return nil, err
+	}
+	entry.DelaySeconds = delaySeconds
+	return entry, nil
+}
+
+// pipelineConfig returns the settings messages are built and sent with
+func (p *Publisher) pipelineConfig() *pipeline.Config {
+	return &pipeline.Config{
+		Fifo:                       strings.HasSuffix(p.cfg.QueueURL, constants.FifoSuffix),
+		MessageGroupIDFunc:         p.cfg.MessageGroupIDFunc,
+		MessageDeduplicationIDFunc: p.cfg.MessageDeduplicationIDFunc,
+		Codec:                      p.cfg.Codec,
+		Compressor:                 p.cfg.Compressor,
+		CompressionThreshold:       p.cfg.CompressionThreshold,
+		PayloadStore:               p.cfg.PayloadStore,
+		PayloadThreshold:           p.cfg.PayloadThreshold,
+		ContinueOnError:            p.cfg.ContinueOnError,
+		RetryPolicy:                p.cfg.RetryPolicy,
+	}
+}
+
+// delayParams returns the delay in seconds the message must be sent with along with the attributes reserved
+// to deliver it. Delays longer than the AWS SQS maximum are sent with the maximum delay and the time the message
+// must be delivered at, so the subscriber keeps re-enqueueing the message until then
+func (p *Publisher) delayParams(cfg *pipeline.Config, msg models.Message) (*int64, models.Attributes, error) {
+	if !msg.Delayed() {
+		return nil, nil, nil
+	}
+
+	if cfg.Fifo {
+		return nil, nil, constants.ErrFifoDelay
+	}
+
+	now := time.Now()
+	deliverAt := msg.DeliveryTime(now)
+	delay := deliverAt.Sub(now)
+	if delay <= 0 {
+		return nil, nil, nil
+	}
+
+	if delay <= constants.MaxDelay {
+		return aws.Int64(int64(delay / time.Second)), nil, nil
+	}
+
+	return aws.Int64(int64(constants.MaxDelay / time.Second)), models.Attributes{constants.DeliverAtAttribute: models.IntAttribute(deliverAt.UnixMilli())}, nil
+}
+
+// messageAttributes converts the message attributes to their AWS SQS representation
+func messageAttributes(attrs models.Attributes) map[string]*sqs.MessageAttributeValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	messageAttributes := make(map[string]*sqs.MessageAttributeValue, len(attrs))
+	for name, attr := range attrs {
+		value := &sqs.MessageAttributeValue{DataType: aws.String(attr.DataType)}
+		if attr.BinaryValue != nil {
+			value.BinaryValue = attr.BinaryValue
+		} else {
+			value.StringValue = aws.String(attr.StringValue)
+		}
+		messageAttributes[name] = value
+	}
+	return messageAttributes
 }
 
 func defaultPublisherConfig(cfg *Config) {
 	if cfg.AWSSession == nil {
 		cfg.AWSSession = session.Must(session.NewSession())
 	}
+
+	if cfg.Codec == nil {
+		cfg.Codec = codec.JSON
+	}
+
+	if cfg.CompressionThreshold == 0 {
+		cfg.CompressionThreshold = constants.DefaultCompressionThreshold
+	}
+
+	if cfg.PayloadThreshold == 0 {
+		cfg.PayloadThreshold = constants.MaxPayloadSize
+	}
 }
 
 // New creates a new AWS SQS publisher
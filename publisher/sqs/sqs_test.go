@@ -2,9 +2,17 @@ package sns
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/creatorstack/htsqs/publisher/models"
+	"github.com/creatorstack/htsqs/publisher/retry"
 	"github.com/stretchr/testify/require"
 )
 
@@ -14,6 +22,14 @@ func (js jsonString) MarshalJSON() ([]byte, error) {
 	return []byte(js), nil
 }
 
+func resultIDs(result *models.BatchResult) []string {
+	ids := make([]string, 0, len(result.Results))
+	for _, msgResult := range result.Results {
+		ids = append(ids, msgResult.ID)
+	}
+	return ids
+}
+
 func TestPublisher(t *testing.T) {
 	queue := make(chan *string, 1)
 	defer close(queue)
@@ -26,6 +42,234 @@ func TestPublisher(t *testing.T) {
 	require.Equal(t, *publishedMessage, `{"msg":"message"}`)
 }
 
+func TestPublisherBatch(t *testing.T) {
+	inputs := make([]models.Message, 0, 12)
+	for i := 0; i < cap(inputs); i++ {
+		inputs = append(inputs, models.Message{
+			ID:   fmt.Sprintf("%d", i),
+			Data: jsonString(fmt.Sprintf(`{"key":"val%d"}`, i)),
+		})
+	}
+
+	queue := make(chan *string, len(inputs))
+	defer close(queue)
+
+	pubs := New(Config{QueueURL: "myQueueURL"})
+	pubs.sqs = &sqsPublisherMock{queue: queue}
+
+	result, err := pubs.PublishBatch(context.TODO(), inputs)
+	require.NoError(t, err)
+	require.Len(t, result.Results, len(inputs))
+	require.Equal(t, len(inputs), result.SuccessCount())
+	require.Zero(t, result.ErrorCount())
+
+	for i, input := range inputs {
+		publishedMessage := <-queue
+		require.Equal(t, jsonString(*publishedMessage), input.Data)
+		require.Equal(t, input.ID, result.Results[i].ID)
+		require.NoError(t, result.Results[i].Err)
+	}
+}
+
+func TestPublisherFifo(t *testing.T) {
+	queue := make(chan *string, 3)
+	defer close(queue)
+	mock := &sqsPublisherMock{queue: queue}
+	pubs := New(Config{
+		QueueURL:           "https://sqs.us-east-1.amazonaws.com/123456789012/myQueue.fifo",
+		MessageGroupIDFunc: func(msg models.Message) string { return "derived" },
+	})
+	pubs.sqs = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.PublishWithOptions(context.TODO(), testString, models.WithGroupID("group"), models.WithDeduplicationID("dedup")))
+	<-queue
+	require.Equal(t, "group", *mock.inputs[0].MessageGroupId)
+	require.Equal(t, "dedup", *mock.inputs[0].MessageDeduplicationId)
+
+	// the deduplication ID is left to AWS, which requires it unless content-based deduplication is enabled
+	require.NoError(t, pubs.Publish(context.TODO(), testString))
+	<-queue
+	require.Equal(t, "derived", *mock.inputs[1].MessageGroupId)
+	require.Nil(t, mock.inputs[1].MessageDeduplicationId)
+
+	pubs.cfg.MessageGroupIDFunc = nil
+	_, err := pubs.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: testString}})
+	require.NoError(t, err)
+	<-queue
+	require.Equal(t, constants.DefaultMessageGroupID, *mock.batchInputs[0].Entries[0].MessageGroupId)
+}
+
+func TestPublisherFifoFieldsOnStandardQueue(t *testing.T) {
+	queue := make(chan *string, 1)
+	defer close(queue)
+	pubs := New(Config{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/myQueue"})
+	pubs.sqs = &sqsPublisherMock{queue: queue}
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.Equal(t, constants.ErrFifoOnlyField, pubs.PublishWithOptions(context.TODO(), testString, models.WithGroupID("group")))
+
+	_, err := pubs.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: testString, DeduplicationID: "dedup"}})
+	require.Equal(t, constants.ErrFifoOnlyField, err)
+
+	// publishing partially, only the offending message fails
+	result, err := pubs.PublishBatchPartial(context.TODO(), []models.Message{
+		{ID: "1", Data: testString},
+		{ID: "2", Data: testString, GroupID: "group"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, result.Results[0].Err)
+	require.Equal(t, constants.ErrFifoOnlyField, result.Results[1].Err)
+	require.True(t, result.Results[1].Local)
+}
+
+func TestPublisherAttributes(t *testing.T) {
+	queue := make(chan *string, 2)
+	defer close(queue)
+	mock := &sqsPublisherMock{queue: queue}
+	pubs := New(Config{QueueURL: "myQueueURL"})
+	pubs.sqs = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.PublishWithOptions(context.TODO(), testString, models.WithAttribute("retries", models.IntAttribute(2))))
+	<-queue
+	require.Equal(t, "Number", *mock.inputs[0].MessageAttributes["retries"].DataType)
+	require.Equal(t, "2", *mock.inputs[0].MessageAttributes["retries"].StringValue)
+
+	_, err := pubs.PublishBatch(context.TODO(), []models.Message{
+		{ID: "1", Data: testString, Attributes: models.Attributes{"tags": models.StringArrayAttribute([]string{"a"})}},
+	})
+	require.NoError(t, err)
+	<-queue
+	attr := mock.batchInputs[0].Entries[0].MessageAttributes["tags"]
+	require.Equal(t, "String.Array", *attr.DataType)
+	require.Equal(t, `["a"]`, *attr.StringValue)
+
+	err = pubs.PublishWithOptions(context.TODO(), testString, models.WithAttribute("retries", models.StringAttribute("")))
+	require.ErrorIs(t, err, constants.ErrInvalidAttributeValue)
+}
+
+func TestPublisherDelay(t *testing.T) {
+	queue := make(chan *string, 4)
+	defer close(queue)
+	mock := &sqsPublisherMock{queue: queue}
+	pubs := New(Config{QueueURL: "myQueueURL"})
+	pubs.sqs = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.PublishWithOptions(context.TODO(), testString, models.WithDelay(90*time.Second)))
+	<-queue
+	require.Equal(t, int64(90), *mock.inputs[0].DelaySeconds)
+	require.NotContains(t, mock.inputs[0].MessageAttributes, constants.DeliverAtAttribute)
+
+	deliverAt := time.Now().Add(2 * time.Hour)
+	require.NoError(t, pubs.PublishWithOptions(context.TODO(), testString, models.WithDeliverAt(deliverAt)))
+	<-queue
+	require.Equal(t, int64(constants.MaxDelay/time.Second), *mock.inputs[1].DelaySeconds)
+	deliverAtMillis, err := strconv.ParseInt(*mock.inputs[1].MessageAttributes[constants.DeliverAtAttribute].StringValue, 10, 64)
+	require.NoError(t, err)
+	require.Equal(t, deliverAt.UnixMilli(), deliverAtMillis)
+
+	// messages to deliver at a past time are delivered right away
+	require.NoError(t, pubs.PublishWithOptions(context.TODO(), testString, models.WithDeliverAt(time.Now().Add(-time.Minute))))
+	<-queue
+	require.Nil(t, mock.inputs[2].DelaySeconds)
+
+	_, err = pubs.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: testString, Delay: time.Minute}})
+	require.NoError(t, err)
+	<-queue
+	require.Equal(t, int64(60), *mock.batchInputs[0].Entries[0].DelaySeconds)
+
+	pubs.cfg.QueueURL = "myQueueURL.fifo"
+	require.Equal(t, constants.ErrFifoDelay, pubs.PublishWithOptions(context.TODO(), testString, models.WithDelay(time.Minute)))
+}
+
+func TestPublisherRetry(t *testing.T) {
+	queue := make(chan *string, 1)
+	defer close(queue)
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	mock := &sqsPublisherMock{queue: queue, errs: []error{throttled, throttled}}
+	pubs := New(Config{QueueURL: "queueURL", RetryPolicy: retry.Policy{MaxAttempts: 3, MinDelay: time.Millisecond}})
+	pubs.sqs = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.Publish(context.TODO(), testString))
+	require.Equal(t, testString, jsonString(*<-queue))
+	require.Len(t, mock.inputs, 3)
+
+	mock.errs = []error{awserr.New("InvalidMessageContents", "Invalid message contents", nil)}
+	require.Error(t, pubs.Publish(context.TODO(), testString))
+	require.Len(t, mock.inputs, 4)
+}
+
+func TestPublisherBatchFailures(t *testing.T) {
+	queue := make(chan *string, 1)
+	defer close(queue)
+	mock := &sqsPublisherMock{
+		queue:           queue,
+		failedEntries:   map[string]int{"2": 1},
+		rejectedEntries: map[string]bool{"3": true},
+	}
+	pubs := New(Config{QueueURL: "queueURL"})
+	pubs.sqs = mock
+
+	msgs := []models.Message{
+		{ID: "1", Data: jsonString(`{"key":"val1"}`)},
+		{ID: "2", Data: jsonString(`{"key":"val2"}`)},
+		{ID: "3", Data: jsonString(`{"key":"val3"}`)},
+	}
+	result, err := pubs.PublishBatch(context.TODO(), msgs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2", "3"}, resultIDs(result))
+	require.NoError(t, result.Results[0].Err)
+	require.EqualError(t, result.Results[1].Err, "internal error")
+	require.Equal(t, "InternalError", result.Results[1].Code)
+	require.False(t, result.Results[1].SenderFault)
+	require.EqualError(t, result.Results[2].Err, "invalid parameter value")
+	require.Equal(t, "InvalidParameterValue", result.Results[2].Code)
+	require.True(t, result.Results[2].SenderFault)
+	require.Len(t, mock.batchInputs, 1)
+}
+
+func TestPublisherResult(t *testing.T) {
+	queue := make(chan *string, 2)
+	defer close(queue)
+	mock := &sqsPublisherMock{queue: queue}
+	pubs := New(Config{QueueURL: "queueURL"})
+	pubs.sqs = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	result, err := pubs.PublishWithResult(context.TODO(), testString)
+	require.NoError(t, err)
+	<-queue
+	require.Equal(t, models.PublishResult{MessageID: "message-1"}, result)
+
+	batchResult, err := pubs.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: testString}})
+	require.NoError(t, err)
+	<-queue
+	require.NoError(t, batchResult.Results[0].Err)
+	require.Equal(t, "message-1-1", batchResult.Results[0].MessageID)
+}
+
+func TestPublisherChecksumMismatch(t *testing.T) {
+	queue := make(chan *string, 2)
+	defer close(queue)
+	mock := &sqsPublisherMock{queue: queue, corrupt: true}
+	pubs := New(Config{QueueURL: "queueURL"})
+	pubs.sqs = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	result, err := pubs.PublishWithResult(context.TODO(), testString)
+	require.Equal(t, constants.ErrChecksumMismatch, err)
+	<-queue
+	require.Equal(t, "message-1", result.MessageID)
+
+	batchResult, err := pubs.PublishBatch(context.TODO(), []models.Message{{ID: "1", Data: testString}})
+	require.NoError(t, err)
+	<-queue
+	require.Equal(t, constants.ErrChecksumMismatch, batchResult.Results[0].Err)
+}
+
 func TestPublisherDefaults(t *testing.T) {
 
 	tt := []struct {
@@ -35,13 +279,13 @@ func TestPublisherDefaults(t *testing.T) {
 	}{
 		{
 			"Custom parameters",
-			Config{AWSSession: session.Must(session.NewSession()), QueueURL: "myQueueURL"},
-			Config{QueueURL: "myQueueURL"},
+			Config{AWSSession: session.Must(session.NewSession()), QueueURL: "myQueueURL", Codec: codec.MessagePack, CompressionThreshold: 2048, PayloadThreshold: 1024},
+			Config{QueueURL: "myQueueURL", Codec: codec.MessagePack, CompressionThreshold: 2048, PayloadThreshold: 1024},
 		},
 		{
 			"Use defaults parameters",
 			Config{},
-			Config{},
+			Config{Codec: codec.JSON, CompressionThreshold: constants.DefaultCompressionThreshold, PayloadThreshold: constants.MaxPayloadSize},
 		},
 	}
 
@@ -0,0 +1,201 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/creatorstack/htsqs/constants"
+)
+
+const (
+	// defaultAckFlushInterval is the maximum time a message deletion waits to be batched with others
+	defaultAckFlushInterval = 100 * time.Millisecond
+
+	// defaultAckMaxAttempts is the number of attempts to delete a message failing within a batch
+	defaultAckMaxAttempts = 3
+)
+
+var (
+	// errAckerStopped is returned when a deletion is requested once the acker is stopped
+	errAckerStopped = errors.New("acker is stopped")
+
+	// errAckUnanswered is returned when the DeleteMessageBatch response reports no result for a message
+	errAckUnanswered = errors.New("no result for the message in the DeleteMessageBatch response")
+)
+
+// ackRequest is a message deletion waiting to be sent in a batch
+type ackRequest struct {
+	message  *SQSMessage
+	attempts int
+	result   chan error
+}
+
+// acker coalesces message deletions into DeleteMessageBatch requests of up to 10 messages, sent once
+// full or when the flush interval elapses. Entries failing within a batch are retried in the next ones
+type acker struct {
+	sub         *Subscriber
+	interval    time.Duration
+	maxAttempts int
+
+	mu       sync.RWMutex
+	stopped  bool
+	requests chan *ackRequest
+	done     chan struct{}
+}
+
+// newAcker creates and starts the acker of the subscriber
+func newAcker(s *Subscriber) *acker {
+	a := &acker{
+		sub:         s,
+		interval:    s.cfg.AckFlushInterval,
+		maxAttempts: s.cfg.AckMaxAttempts,
+		requests:    make(chan *ackRequest, constants.MaxBatchSize),
+		done:        make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// delete requests the deletion of the message and waits for its result. The context only bounds the wait
+// for the request to be queued: once queued, the deletion is carried out and its result returned
+func (a *acker) delete(ctx context.Context, m *SQSMessage) error {
+	req := &ackRequest{message: m, result: make(chan error, 1)}
+
+	a.mu.RLock()
+	if a.stopped {
+		a.mu.RUnlock()
+		return errAckerStopped
+	}
+	select {
+	case a.requests <- req:
+	case <-ctx.Done():
+		a.mu.RUnlock()
+		return ctx.Err()
+	}
+	a.mu.RUnlock()
+
+	return <-req.result
+}
+
+// stop flushes the pending deletions and waits for the acker to return
+func (a *acker) stop() {
+	a.mu.Lock()
+	if !a.stopped {
+		a.stopped = true
+		close(a.requests)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+// run batches the requested deletions until the acker is stopped
+func (a *acker) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	var pending []*ackRequest
+	for {
+		select {
+		case req, ok := <-a.requests:
+			if !ok {
+				for len(pending) > 0 {
+					pending = a.flush(pending)
+				}
+				return
+			}
+			pending = append(pending, req)
+			if len(pending) >= constants.MaxBatchSize {
+				pending = a.flush(pending)
+			}
+		case <-ticker.C:
+			if len(pending) > 0 {
+				pending = a.flush(pending)
+			}
+		}
+	}
+}
+
+// flush sends the first batch of pending deletions, returning the ones left pending, including
+// the failed ones to retry
+func (a *acker) flush(pending []*ackRequest) []*ackRequest {
+	batch := pending
+	if len(batch) > constants.MaxBatchSize {
+		batch = batch[:constants.MaxBatchSize]
+	}
+	rest := append([]*ackRequest(nil), pending[len(batch):]...)
+
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, len(batch))
+	for idx, req := range batch {
+		req.attempts++
+		entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(idx)),
+			ReceiptHandle: req.message.rawMessage.ReceiptHandle,
+		})
+	}
+
+	output, err := a.sub.sqs.DeleteMessageBatchWithContext(context.Background(), &sqs.DeleteMessageBatchInput{
+		Entries:  entries,
+		QueueUrl: &a.sub.cfg.SqsQueueURL,
+	})
+	if err != nil {
+		for _, req := range batch {
+			rest = a.retry(rest, req, err)
+		}
+		return rest
+	}
+
+	answered := make(map[*ackRequest]bool, len(batch))
+	for _, entry := range output.Successful {
+		if req := a.find(batch, entry.Id); req != nil && !answered[req] {
+			answered[req] = true
+			a.sub.untrack(req.message)
+			req.result <- nil
+		}
+	}
+	for _, entry := range output.Failed {
+		if req := a.find(batch, entry.Id); req != nil && !answered[req] {
+			answered[req] = true
+			err := fmt.Errorf("%s: %s", aws.StringValue(entry.Code), aws.StringValue(entry.Message))
+			// sender faults, such as an invalid receipt handle, fail the same way when retried
+			if aws.BoolValue(entry.SenderFault) {
+				req.result <- err
+				continue
+			}
+			rest = a.retry(rest, req, err)
+		}
+	}
+
+	// entries missing from the response are retried like failed ones, so that no deletion waits forever
+	for _, req := range batch {
+		if !answered[req] {
+			rest = a.retry(rest, req, errAckUnanswered)
+		}
+	}
+	return rest
+}
+
+// retry adds the request back to the pending ones while attempts remain, otherwise reports the error
+func (a *acker) retry(pending []*ackRequest, req *ackRequest, err error) []*ackRequest {
+	if req.attempts < a.maxAttempts {
+		return append(pending, req)
+	}
+	req.result <- err
+	return pending
+}
+
+// find returns the request of the batch entry ID
+func (a *acker) find(batch []*ackRequest, id *string) *ackRequest {
+	idx, err := strconv.Atoi(aws.StringValue(id))
+	if err != nil || idx < 0 || idx >= len(batch) {
+		return nil
+	}
+	return batch[idx]
+}
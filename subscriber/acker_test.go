@@ -0,0 +1,148 @@
+package subscriber
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+// newAckingSubscriber returns a consuming subscriber batching its acknowledgements
+func newAckingSubscriber(t *testing.T, cfg Config, mock *sqsMock) *Subscriber {
+	cfg.AckBatching = true
+	subs := New(cfg)
+	subs.sqs = mock
+
+	_, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+	return subs
+}
+
+func TestAckerBatches(t *testing.T) {
+	mock := &sqsMock{}
+	subs := newAckingSubscriber(t, Config{AckFlushInterval: 500 * time.Millisecond}, mock)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 12)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = newTestMessage(subs, strconv.Itoa(i)).Done()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	// a full batch of 10 and the remaining 2 once the flush interval elapses
+	calls, handles := mock.deletedInBatches()
+	require.Equal(t, 2, calls)
+	require.Len(t, handles, 12)
+	require.NoError(t, subs.Stop())
+}
+
+func TestAckerRetry(t *testing.T) {
+	mock := &sqsMock{
+		failedDeletes:   map[string]int{"transient": 2, "failing": 5},
+		rejectedDeletes: map[string]bool{"rejected": true},
+	}
+	subs := newAckingSubscriber(t, Config{AckFlushInterval: 10 * time.Millisecond}, mock)
+
+	results := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range []string{"ok", "transient", "failing", "rejected"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			err := newTestMessage(subs, id).DoneWithContext(context.TODO())
+			mu.Lock()
+			results[id] = err
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	require.NoError(t, results["ok"])
+	// failed entries are retried until attempts are exhausted
+	require.NoError(t, results["transient"])
+	require.EqualError(t, results["failing"], "InternalError: internal error")
+	// sender faults are not retried
+	require.EqualError(t, results["rejected"], "ReceiptHandleIsInvalid: invalid receipt handle")
+
+	_, handles := mock.deletedInBatches()
+	count := make(map[string]int)
+	for _, handle := range handles {
+		count[handle]++
+	}
+	require.Equal(t, map[string]int{"ok": 1, "transient": 3, "failing": 3, "rejected": 1}, count)
+	require.NoError(t, subs.Stop())
+}
+
+func TestAckerUnanswered(t *testing.T) {
+	mock := &sqsMock{unansweredDeletes: map[string]int{"once": 1, "never": 5}}
+	subs := newAckingSubscriber(t, Config{AckFlushInterval: 10 * time.Millisecond}, mock)
+
+	results := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range []string{"once", "never"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			err := newTestMessage(subs, id).Done()
+			mu.Lock()
+			results[id] = err
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	// entries missing from the response are retried until attempts are exhausted
+	require.NoError(t, results["once"])
+	require.Equal(t, errAckUnanswered, results["never"])
+	require.NoError(t, subs.Stop())
+}
+
+func TestAckerQueuedDeletionOutlivesContext(t *testing.T) {
+	mock := &sqsMock{}
+	subs := newAckingSubscriber(t, Config{AckFlushInterval: 50 * time.Millisecond}, mock)
+
+	// the deletion queued before the context is done is carried out and reported as such
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	require.NoError(t, newTestMessage(subs, "queued").DoneWithContext(ctx))
+	_, handles := mock.deletedInBatches()
+	require.Equal(t, []string{"queued"}, handles)
+	require.NoError(t, subs.Stop())
+}
+
+func TestAckerFlushOnStop(t *testing.T) {
+	deleted := make(chan *sqs.DeleteMessageInput, 1)
+	mock := &sqsMock{deleted: deleted}
+	subs := newAckingSubscriber(t, Config{AckFlushInterval: time.Hour}, mock)
+
+	done := make(chan error)
+	go func() {
+		done <- newTestMessage(subs, "pending").Done()
+	}()
+	// let the deletion reach the acker
+	time.Sleep(50 * time.Millisecond)
+
+	// the pending deletion is sent on Stop without waiting for the flush interval
+	require.NoError(t, subs.Stop())
+	require.NoError(t, <-done)
+	_, handles := mock.deletedInBatches()
+	require.Equal(t, []string{"pending"}, handles)
+
+	// once stopped, messages are deleted one by one
+	require.NoError(t, newTestMessage(subs, "late").Done())
+	require.Equal(t, aws.String("late"), (<-deleted).ReceiptHandle)
+}
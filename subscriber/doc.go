@@ -8,5 +8,8 @@
 //
 // Worker
 //
-// Worker is the service implementation of a Subscriber.
+// Worker is the service implementation of a Subscriber. Its message handler can be wrapped with middlewares
+// such as Recovery, Timeout, Logging, Latency and AckOnSuccess through 'Use'. Alternatively, an error returning
+// Handler acknowledges the messages according to its outcome: deleted on success, retried after a backoff delay
+// on retryable errors and sent to a dead-letter sink on permanent errors.
 package subscriber
@@ -0,0 +1,154 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/jpillora/backoff"
+)
+
+// Handler handles the messages received by a Worker, returning the processing error. The message is
+// deleted when the error is nil, made visible again after a backoff delay when the error is retryable,
+// and sent to the dead-letter sink when the error is permanent. See Permanent
+type Handler interface {
+	Handle(ctx context.Context, m *SQSMessage) error
+}
+
+// HandlerFunc allows to use ordinary functions as a Handler
+type HandlerFunc func(ctx context.Context, m *SQSMessage) error
+
+// Handle calls f(ctx, m)
+func (f HandlerFunc) Handle(ctx context.Context, m *SQSMessage) error {
+	return f(ctx, m)
+}
+
+// PermanentError is a handler error that must not be retried
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks the handler error as permanent, routing the message to the dead-letter sink instead of retrying it
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether the handler error has been marked as permanent
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr)
+}
+
+// DeadLetterSink receives the messages whose handler failed with a permanent error.
+// The message is deleted once successfully sent to the sink
+type DeadLetterSink interface {
+	Send(ctx context.Context, m *SQSMessage, cause error) error
+}
+
+// defaultDeadLetterReason is the dead-letter reason of the messages whose handler error has no message
+const defaultDeadLetterReason = "permanent handler failure"
+
+// SQSDeadLetterSink sends the messages to a dead-letter AWS SQS queue with the handler error
+// in the dead-letter reason attribute. Messages sent to a FIFO queue keep their message group ID
+// and deduplication ID, defaulting to the default message group and to their message ID
+type SQSDeadLetterSink struct {
+
+	// SQS queue the messages are sent to
+	QueueURL string
+}
+
+// Send allows SQSDeadLetterSink to implement the DeadLetterSink interface
+func (d *SQSDeadLetterSink) Send(ctx context.Context, m *SQSMessage, cause error) error {
+	attrs := make(map[string]*sqs.MessageAttributeValue, len(m.rawMessage.MessageAttributes)+1)
+	for name, attr := range m.rawMessage.MessageAttributes {
+		attrs[name] = attr
+	}
+	if len(attrs) < constants.MaxMessageAttributes {
+		// AWS rejects empty string attributes
+		reason := defaultDeadLetterReason
+		if cause != nil && cause.Error() != "" {
+			reason = cause.Error()
+		}
+		attrs[constants.DeadLetterReasonAttribute] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(reason),
+		}
+	}
+
+	input := &sqs.SendMessageInput{
+		MessageAttributes: attrs,
+		MessageBody:       m.rawMessage.Body,
+		QueueUrl:          &d.QueueURL,
+	}
+	if strings.HasSuffix(d.QueueURL, constants.FifoSuffix) {
+		groupID, deduplicationID := m.MessageGroupID(), m.MessageDeduplicationID()
+		if groupID == "" {
+			groupID = constants.DefaultMessageGroupID
+		}
+		if deduplicationID == "" {
+			deduplicationID = m.MessageID()
+		}
+		input.MessageGroupId, input.MessageDeduplicationId = &groupID, &deduplicationID
+	}
+
+	_, err := m.sub.sqs.SendMessageWithContext(ctx, input)
+	return err
+}
+
+// defaultRetryBackoff returns the backoff policy of the messages whose handler failed with a retryable error
+func defaultRetryBackoff() *backoff.Backoff {
+	return &backoff.Backoff{
+		Factor: 2,
+		Min:    time.Second,
+		Max:    15 * time.Minute,
+		Jitter: true,
+	}
+}
+
+// handlerMessageHandler adapts the Handler to a MessageHandler acknowledging the messages according to the handler error
+func handlerMessageHandler(handler Handler) MessageHandler {
+	return func(ctx context.Context, w *Worker, m *SQSMessage) {
+		handlerErr := handler.Handle(ctx, m)
+		// the message is acknowledged even when the handler context is cancelled on shutdown,
+		// otherwise it would be released and handled again
+		if err := w.settle(context.WithoutCancel(ctx), m, handlerErr); err != nil {
+			w.config.Subscriber.cfg.Logger.Printf("Error when acknowledging message from SQS: %v\n", err)
+		}
+	}
+}
+
+// settle deletes the message when handled successfully, makes it visible again after a backoff delay
+// growing with its receive count when the handler error is retryable, and sends it to the dead-letter sink
+// when permanent. Without dead-letter sink, messages failing permanently are left to the queue redrive policy
+func (w *Worker) settle(ctx context.Context, m *SQSMessage, handlerErr error) error {
+	switch {
+	case handlerErr == nil:
+		return m.DoneWithContext(ctx)
+
+	case IsPermanent(handlerErr):
+		if w.config.DeadLetterSink == nil {
+			w.config.Subscriber.cfg.Logger.Printf("Message failed permanently with no dead-letter sink: %v\n", handlerErr)
+			return nil
+		}
+		if err := w.config.DeadLetterSink.Send(ctx, m, handlerErr); err != nil {
+			return err
+		}
+		// the offloaded payload is kept, the dead-letter message still points to it
+		return m.delete(ctx, false)
+
+	default:
+		return m.NackWithContext(ctx, w.config.RetryBackoff)
+	}
+}
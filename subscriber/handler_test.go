@@ -0,0 +1,151 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/jpillora/backoff"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	var (
+		deleted    = make(chan *sqs.DeleteMessageInput, 1)
+		visibility = make(chan *sqs.ChangeMessageVisibilityInput, 1)
+		sent       = make(chan *sqs.SendMessageInput, 1)
+		logger     = &loggerMock{}
+		failure    = errors.New("handler failure")
+	)
+	subs := New(Config{Logger: logger})
+	subs.sqs = &sqsMock{deleted: deleted, visibility: visibility, sent: sent}
+
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: HandlerFunc(func(ctx context.Context, m *SQSMessage) error {
+			switch *m.rawMessage.MessageId {
+			case "retryable":
+				return failure
+			case "permanent":
+				return fmt.Errorf("handling: %w", Permanent(failure))
+			}
+			return nil
+		}),
+		RetryBackoff: &backoff.Backoff{Min: 10 * time.Second, Max: time.Hour, Factor: 2},
+	})
+	handler := worker.handler()
+
+	handler(context.TODO(), worker, newTestMessage(subs, "success"))
+	require.Equal(t, "success", *(<-deleted).ReceiptHandle)
+
+	// retryable errors make the message visible again after a delay growing with its receive count
+	m := newTestMessage(subs, "retryable")
+	m.rawMessage.Attributes = map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("3")}
+	handler(context.TODO(), worker, m)
+	require.Equal(t, int64(40), *(<-visibility).VisibilityTimeout)
+	require.Empty(t, deleted)
+
+	// without dead-letter sink, permanent errors leave the message to the queue redrive policy
+	handler(context.TODO(), worker, newTestMessage(subs, "permanent"))
+	require.Empty(t, deleted)
+	require.Empty(t, visibility)
+	require.Len(t, logger.lines, 1)
+
+	worker.config.DeadLetterSink = &SQSDeadLetterSink{QueueURL: "deadLetterQueueURL"}
+	handler(context.TODO(), worker, newTestMessage(subs, "permanent"))
+	input := <-sent
+	require.Equal(t, "deadLetterQueueURL", *input.QueueUrl)
+	require.Equal(t, "message", *input.MessageBody)
+	require.Equal(t, "handling: handler failure", *input.MessageAttributes[constants.DeadLetterReasonAttribute].StringValue)
+	require.Equal(t, "permanent", *(<-deleted).ReceiptHandle)
+}
+
+func TestHandlerCorruptPayload(t *testing.T) {
+	var (
+		deleted = make(chan *sqs.DeleteMessageInput, 2)
+		sent    = make(chan *sqs.SendMessageInput, 2)
+	)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{deleted: deleted, sent: sent}
+
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: HandlerFunc(func(ctx context.Context, m *SQSMessage) error {
+			var v map[string]string
+			return m.Decode(&v)
+		}),
+		DeadLetterSink: &SQSDeadLetterSink{QueueURL: "deadLetterQueueURL"},
+	})
+	handler := worker.handler()
+
+	// payloads that cannot be decoded fail permanently and are sent to the dead-letter sink instead of being retried
+	for id, attr := range map[string]string{
+		"encoding":     constants.ContentEncodingAttribute,
+		"content-type": constants.ContentTypeAttribute,
+	} {
+		m := newTestMessage(subs, id)
+		m.rawMessage.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+			attr: {DataType: aws.String("String"), StringValue: aws.String("unknown")},
+		}
+		require.True(t, IsPermanent(m.Decode(nil)))
+
+		handler(context.TODO(), worker, m)
+		require.Equal(t, "deadLetterQueueURL", *(<-sent).QueueUrl)
+		require.Equal(t, id, *(<-deleted).ReceiptHandle)
+	}
+}
+
+func TestSQSDeadLetterSink(t *testing.T) {
+	sent := make(chan *sqs.SendMessageInput, 1)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{sent: sent}
+
+	// standard queues take no FIFO parameters, errors without message get a default reason
+	sink := &SQSDeadLetterSink{QueueURL: "deadLetterQueueURL"}
+	require.NoError(t, sink.Send(context.TODO(), newTestMessage(subs, "standard"), errors.New("")))
+	input := <-sent
+	require.Nil(t, input.MessageGroupId)
+	require.Nil(t, input.MessageDeduplicationId)
+	require.Equal(t, defaultDeadLetterReason, *input.MessageAttributes[constants.DeadLetterReasonAttribute].StringValue)
+
+	// FIFO queues keep the group and deduplication IDs of the message
+	sink = &SQSDeadLetterSink{QueueURL: "deadLetterQueueURL.fifo"}
+	m := newTestMessage(subs, "fifo")
+	m.rawMessage.Attributes = map[string]*string{
+		sqs.MessageSystemAttributeNameMessageGroupId:         aws.String("group"),
+		sqs.MessageSystemAttributeNameMessageDeduplicationId: aws.String("dedup"),
+	}
+	require.NoError(t, sink.Send(context.TODO(), m, errors.New("failure")))
+	input = <-sent
+	require.Equal(t, "group", *input.MessageGroupId)
+	require.Equal(t, "dedup", *input.MessageDeduplicationId)
+
+	// defaulting to the default group and to the message ID
+	require.NoError(t, sink.Send(context.TODO(), newTestMessage(subs, "fifo"), errors.New("failure")))
+	input = <-sent
+	require.Equal(t, constants.DefaultMessageGroupID, *input.MessageGroupId)
+	require.Equal(t, "fifo", *input.MessageDeduplicationId)
+}
+
+func TestHandlerDefaults(t *testing.T) {
+	worker := NewWorker(WorkerConfig{
+		Subscriber: New(Config{}),
+		Handler:    HandlerFunc(func(ctx context.Context, m *SQSMessage) error { return nil }),
+	})
+	require.NotNil(t, worker.config.MessageHandler)
+	require.Equal(t, defaultRetryBackoff(), worker.config.RetryBackoff)
+}
+
+func TestIsPermanent(t *testing.T) {
+	failure := errors.New("handler failure")
+	require.False(t, IsPermanent(failure))
+	require.True(t, IsPermanent(Permanent(failure)))
+	require.True(t, IsPermanent(fmt.Errorf("wrapped: %w", Permanent(failure))))
+	require.True(t, errors.Is(Permanent(failure), failure))
+	require.Equal(t, failure.Error(), Permanent(failure).Error())
+}
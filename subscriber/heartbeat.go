@@ -0,0 +1,76 @@
+package subscriber
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultVisibilityTimeout is the visibility timeout of the messages when not set, the AWS SQS queue default
+	defaultVisibilityTimeout = 30 * time.Second
+)
+
+// visibilityTimeout returns the visibility timeout of the messages received
+func visibilityTimeout(cfg *Config) time.Duration {
+	if cfg.VisibilityTimeout == nil || *cfg.VisibilityTimeout <= 0 {
+		return defaultVisibilityTimeout
+	}
+	return time.Duration(*cfg.VisibilityTimeout) * time.Second
+}
+
+// heartbeat extends the visibility timeout of the messages received and not yet acknowledged every
+// HeartbeatInterval, until the consume context is done, or the subscriber is stopped and no message
+// is left to extend
+func (s *Subscriber) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	extension := int64(visibilityTimeout(&s.cfg) / time.Second)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		msgs := s.inProcess()
+		if len(msgs) == 0 {
+			select {
+			case <-s.quit:
+				return
+			default:
+				continue
+			}
+		}
+
+		for message, err := range s.changeVisibility(context.Background(), msgs, extension) {
+			// the message may have been acknowledged in the meantime
+			if message.settled.isSet() {
+				continue
+			}
+			s.cfg.Logger.Printf("Error when extending the visibility timeout of message: %v\n", err)
+			if message.cancel != nil {
+				message.cancel(fmt.Errorf("extending the visibility timeout: %w", err))
+			}
+		}
+	}
+}
+
+// inProcess returns the messages received and not yet acknowledged whose visibility timeout must be extended
+func (s *Subscriber) inProcess() []*SQSMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := make([]*SQSMessage, 0, len(s.unacked))
+	for message := range s.unacked {
+		if message.settled.isSet() || message.handled.isSet() || message.Context().Err() != nil {
+			continue
+		}
+		if time.Since(message.receivedAt) >= s.cfg.MaxProcessingTime {
+			continue
+		}
+		msgs = append(msgs, message)
+	}
+	return msgs
+}
@@ -0,0 +1,156 @@
+package subscriber
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+// extensions returns the number of times the visibility timeout of the message was changed in batches
+func extensions(mock *sqsMock, handle string) int {
+	count := 0
+	for _, h := range mock.visibilityHandles() {
+		if h == handle {
+			count++
+		}
+	}
+	return count
+}
+
+func newHeartbeatSubscriber(t *testing.T, cfg Config, mock *sqsMock) (*Subscriber, <-chan *SQSMessage) {
+	cfg.VisibilityHeartbeat = true
+	cfg.VisibilityTimeout = aws.Int64(60)
+	cfg.HeartbeatInterval = 10 * time.Millisecond
+	cfg.NumConsumers = 1
+	subs := New(cfg)
+	subs.sqs = mock
+
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+	return subs, messages
+}
+
+func TestHeartbeat(t *testing.T) {
+	queue := make(chan *SQSMessage, 1)
+	mock := &sqsMock{queue: queue}
+	subs, messages := newHeartbeatSubscriber(t, Config{}, mock)
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("message")}}
+	m := <-messages
+
+	// the visibility timeout is extended while the message is in process
+	require.Eventually(t, func() bool { return extensions(mock, "message") >= 2 }, time.Second, 10*time.Millisecond)
+	mock.mu.Lock()
+	require.Equal(t, int64(60), *mock.visibilityBatches[0].Entries[0].VisibilityTimeout)
+	mock.mu.Unlock()
+
+	// and no longer once done
+	require.NoError(t, m.Done())
+	extended := extensions(mock, "message")
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, extended, extensions(mock, "message"))
+	require.NoError(t, m.Context().Err())
+	require.NoError(t, subs.Stop())
+}
+
+func TestHeartbeatMaxProcessingTime(t *testing.T) {
+	queue := make(chan *SQSMessage, 1)
+	mock := &sqsMock{queue: queue}
+	subs, messages := newHeartbeatSubscriber(t, Config{MaxProcessingTime: 50 * time.Millisecond}, mock)
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("message")}}
+	<-messages
+
+	// the visibility timeout is no longer extended past the maximum processing time
+	time.Sleep(100 * time.Millisecond)
+	extended := extensions(mock, "message")
+	require.NotZero(t, extended)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, extended, extensions(mock, "message"))
+	require.NoError(t, subs.Stop())
+}
+
+func TestHeartbeatContextCancel(t *testing.T) {
+	queue := make(chan *SQSMessage, 1)
+	mock := &sqsMock{queue: queue}
+	subs := New(Config{VisibilityHeartbeat: true, HeartbeatInterval: 10 * time.Millisecond, NumConsumers: 1})
+	subs.sqs = mock
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	messages, _, err := subs.Consume(ctx)
+	require.NoError(t, err)
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("message")}}
+	<-messages
+	require.Eventually(t, func() bool { return extensions(mock, "message") >= 1 }, time.Second, 10*time.Millisecond)
+
+	// the heartbeat stops with the consume context, even though the subscriber is not stopped
+	cancel()
+	time.Sleep(30 * time.Millisecond)
+	extended := extensions(mock, "message")
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, extended, extensions(mock, "message"))
+}
+
+func TestHeartbeatHandlerReturned(t *testing.T) {
+	queue := make(chan *SQSMessage, 1)
+	mock := &sqsMock{queue: queue}
+	subs := New(Config{VisibilityHeartbeat: true, HeartbeatInterval: 10 * time.Millisecond, NumConsumers: 1})
+	subs.sqs = mock
+
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			<-release
+			close(returned)
+		},
+	})
+	go worker.Start(context.TODO())
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("message")}}
+	require.Eventually(t, func() bool { return extensions(mock, "message") >= 1 }, time.Second, 10*time.Millisecond)
+
+	// the message is no longer extended once its handler returns, even though it was not acknowledged
+	close(release)
+	<-returned
+	time.Sleep(30 * time.Millisecond)
+	extended := extensions(mock, "message")
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, extended, extensions(mock, "message"))
+	require.NoError(t, worker.Stop(context.TODO()))
+}
+
+func TestHeartbeatFailureCancelsHandler(t *testing.T) {
+	queue := make(chan *SQSMessage, 1)
+	mock := &sqsMock{queue: queue, failedVisibility: map[string]bool{"message": true}}
+	subs := New(Config{VisibilityHeartbeat: true, HeartbeatInterval: 10 * time.Millisecond, NumConsumers: 1})
+	subs.sqs = mock
+
+	cause := make(chan error, 1)
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			<-ctx.Done()
+			cause <- context.Cause(ctx)
+		},
+	})
+	go worker.Start(context.TODO())
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("message")}}
+
+	// the handler is cancelled once extending the visibility timeout of its message fails
+	select {
+	case err := <-cause:
+		require.EqualError(t, err, "extending the visibility timeout: ReceiptHandleIsInvalid: invalid receipt handle")
+	case <-time.After(time.Second):
+		t.Fatal("handler not cancelled")
+	}
+	// releasing the message on shutdown fails the same way
+	require.EqualError(t, worker.Stop(context.TODO()), "changing the visibility of 1 messages failed: ReceiptHandleIsInvalid: invalid receipt handle")
+}
@@ -1,18 +1,97 @@
 package subscriber
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/creatorstack/htsqs/blobstore"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/compression"
+	"github.com/creatorstack/htsqs/constants"
+	"github.com/jpillora/backoff"
 )
 
 // SQSMessage is the implementation of a SQS message
 type SQSMessage struct {
 	sub        *Subscriber
 	rawMessage *sqs.Message
+
+	// payload holds the payload once resolved from the payload store and decoded, on first access
+	resolveOnce sync.Once
+	payload     []byte
+	payloadErr  error
+
+	// settled is set once the message has been successfully deleted or its visibility changed
+	settled atomicBool
+
+	// handled is set once the handler of the Worker has returned, see VisibilityHeartbeat
+	handled atomicBool
+
+	// ctx is cancelled when extending the visibility timeout of the message fails
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	receivedAt time.Time
 }
 
-// Body returns the body of the SQS message in bytes
+// Body returns the body of the SQS message in bytes. Offloaded payloads are
+// transparently resolved from the subscriber payload store, and encoded or compressed payloads decoded.
+// The raw message body is returned when this fails, see PayloadErr
 func (m *SQSMessage) Body() []byte {
-	return []byte(*m.rawMessage.Body)
+	if m.resolvePayload() != nil {
+		return []byte(aws.StringValue(m.rawMessage.Body))
+	}
+	return m.payload
+}
+
+// PayloadErr returns the error resolving the offloaded payload or decoding the content of the message,
+// nil when Body returns the payload. Corrupt payloads fail with a permanent error, so that handlers returning
+// it send the message to the dead-letter sink instead of retrying it
+func (m *SQSMessage) PayloadErr() error {
+	return m.resolvePayload()
+}
+
+// resolvePayload resolves the payload of the message the first time it is accessed
+func (m *SQSMessage) resolvePayload() error {
+	m.resolveOnce.Do(func() {
+		m.payload, m.payloadErr = m.sub.resolvePayload(m.Context(), m.rawMessage)
+	})
+	return m.payloadErr
+}
+
+// Decode unmarshals the message body into the value pointed to by v, using the codec of the content type
+// the message was published with or, when it has no content type attribute, the subscriber codec
+func (m *SQSMessage) Decode(v interface{}) error {
+	if err := m.resolvePayload(); err != nil {
+		return err
+	}
+
+	c := m.sub.cfg.Codec
+	if attr, ok := m.rawMessage.MessageAttributes[constants.ContentTypeAttribute]; ok {
+		contentType := aws.StringValue(attr.StringValue)
+		if contentType != c.ContentType() {
+			if c, ok = codec.ForContentType(contentType); !ok {
+				return Permanent(fmt.Errorf("no codec registered for content type %q", contentType))
+			}
+		}
+	}
+	return c.Unmarshal(m.payload, v)
+}
+
+// Context returns the context of the message. With VisibilityHeartbeat, it is cancelled when extending the
+// visibility timeout of the message fails, context.Cause returning the error
+func (m *SQSMessage) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
 }
 
 // MessageAttributes returns the message attributes
@@ -20,19 +99,67 @@ func (m *SQSMessage) MessageAttributes() map[string]*sqs.MessageAttributeValue {
 	return m.rawMessage.MessageAttributes
 }
 
-// Done deletes the message from SQS.
+// Done deletes the message from SQS. When DeleteOffloadedPayloads is set,
+// its offloaded payload is removed from the payload store too.
 func (m *SQSMessage) Done() error {
+	return m.DoneWithContext(context.Background())
+}
+
+// DoneWithContext is the same as Done with the ability to pass a context, cancelling the deletion when it is done.
+// With AckBatching, the context only bounds the wait for the deletion to be queued in a batch
+func (m *SQSMessage) DoneWithContext(ctx context.Context) error {
+	return m.delete(ctx, m.sub.cfg.DeleteOffloadedPayloads)
+}
+
+// delete deletes the message from SQS, along with its offloaded payload when deletePayload is set.
+// With AckBatching, the deletion is sent in a batch with others
+func (m *SQSMessage) delete(ctx context.Context, deletePayload bool) error {
+	if err := m.deleteMessage(ctx); err != nil {
+		return err
+	}
+	m.settled.setTrue()
+
+	if !deletePayload || m.sub.cfg.PayloadStore == nil {
+		return nil
+	}
+	if _, ok := m.rawMessage.MessageAttributes[constants.OffloadedPayloadAttribute]; !ok {
+		return nil
+	}
+	pointer, err := blobstore.ParsePointer([]byte(aws.StringValue(m.rawMessage.Body)))
+	if err != nil {
+		return err
+	}
+	return m.sub.cfg.PayloadStore.Delete(ctx, pointer.Key)
+}
+
+// deleteMessage deletes the message from SQS, through the subscriber acker unless it is stopped
+func (m *SQSMessage) deleteMessage(ctx context.Context) error {
+	if m.sub.acker != nil {
+		if err := m.sub.acker.delete(ctx, m); !errors.Is(err, errAckerStopped) {
+			return err
+		}
+	}
+
 	deleteParams := &sqs.DeleteMessageInput{
 		QueueUrl:      &m.sub.cfg.SqsQueueURL,
 		ReceiptHandle: m.rawMessage.ReceiptHandle,
 	}
-	_, err := m.sub.sqs.DeleteMessage(deleteParams)
-	return err
+	if _, err := m.sub.sqs.DeleteMessageWithContext(ctx, deleteParams); err != nil {
+		return err
+	}
+	m.sub.untrack(m)
+	return nil
 }
 
 // ChangeMessageVisibility modifies current message visibility timeout to the one specified in the parameters.
 // This is normally useful when the message processing is taking more time than the default visibility timeout
 func (m *SQSMessage) ChangeMessageVisibility(newVisibilityTimeout *int64) error {
+	return m.ChangeMessageVisibilityWithContext(context.Background(), newVisibilityTimeout)
+}
+
+// ChangeMessageVisibilityWithContext is the same as ChangeMessageVisibility with the ability to pass a context,
+// cancelling the change when it is done
+func (m *SQSMessage) ChangeMessageVisibilityWithContext(ctx context.Context, newVisibilityTimeout *int64) error {
 	changeVisibilityParams := &sqs.ChangeMessageVisibilityInput{
 		QueueUrl:          &m.sub.cfg.SqsQueueURL,
 		ReceiptHandle:     m.rawMessage.ReceiptHandle,
@@ -44,6 +171,125 @@ func (m *SQSMessage) ChangeMessageVisibility(newVisibilityTimeout *int64) error
 		return err
 	}
 
-	_, err := m.sub.sqs.ChangeMessageVisibility(changeVisibilityParams)
-	return err
+	if _, err := m.sub.sqs.ChangeMessageVisibilityWithContext(ctx, changeVisibilityParams); err != nil {
+		return err
+	}
+	m.settled.setTrue()
+	m.sub.untrack(m)
+	return nil
+}
+
+// Nack makes the message visible again after a delay growing with the number of times it has been received,
+// according to the exponential backoff policy. The delay is capped to the AWS SQS maximum visibility timeout.
+// Defaults to a backoff from 1 second up to 15 minutes when the policy is nil
+func (m *SQSMessage) Nack(policy *backoff.Backoff) error {
+	return m.NackWithContext(context.Background(), policy)
+}
+
+// NackWithContext is the same as Nack with the ability to pass a context, cancelling the change when it is done
+func (m *SQSMessage) NackWithContext(ctx context.Context, policy *backoff.Backoff) error {
+	if policy == nil {
+		policy = defaultRetryBackoff()
+	}
+
+	delay := policy.ForAttempt(float64(m.receiveCount() - 1))
+	if delay > constants.MaxVisibilityTimeout {
+		delay = constants.MaxVisibilityTimeout
+	}
+	return m.ChangeMessageVisibilityWithContext(ctx, aws.Int64(int64(delay/time.Second)))
+}
+
+// MessageID returns the identifier assigned to the message by AWS SQS
+func (m *SQSMessage) MessageID() string {
+	return aws.StringValue(m.rawMessage.MessageId)
+}
+
+// ReceiptHandle returns the handle of the message receipt, required to delete it or change its visibility
+func (m *SQSMessage) ReceiptHandle() string {
+	return aws.StringValue(m.rawMessage.ReceiptHandle)
+}
+
+// ApproximateReceiveCount returns the number of times the message has been received, 0 when not requested
+func (m *SQSMessage) ApproximateReceiveCount() int {
+	count, _ := strconv.Atoi(m.attribute(sqs.MessageSystemAttributeNameApproximateReceiveCount))
+	return count
+}
+
+// SentTimestamp returns the time the message was sent to the queue, the zero time when not requested
+func (m *SQSMessage) SentTimestamp() time.Time {
+	return m.timestampAttribute(sqs.MessageSystemAttributeNameSentTimestamp)
+}
+
+// ApproximateFirstReceiveTimestamp returns the time the message was first received from the queue,
+// the zero time when not requested
+func (m *SQSMessage) ApproximateFirstReceiveTimestamp() time.Time {
+	return m.timestampAttribute(sqs.MessageSystemAttributeNameApproximateFirstReceiveTimestamp)
+}
+
+// MessageGroupID returns the group of a FIFO queue message, empty otherwise
+func (m *SQSMessage) MessageGroupID() string {
+	return m.attribute(sqs.MessageSystemAttributeNameMessageGroupId)
+}
+
+// MessageDeduplicationID returns the deduplication ID of a FIFO queue message, empty otherwise
+func (m *SQSMessage) MessageDeduplicationID() string {
+	return m.attribute(sqs.MessageSystemAttributeNameMessageDeduplicationId)
+}
+
+// SequenceNumber returns the sequence number assigned to a FIFO queue message, empty otherwise
+func (m *SQSMessage) SequenceNumber() string {
+	return m.attribute(sqs.MessageSystemAttributeNameSequenceNumber)
+}
+
+// AWSTraceHeader returns the AWS X-Ray trace header of the message, empty when not traced
+func (m *SQSMessage) AWSTraceHeader() string {
+	return m.attribute(sqs.MessageSystemAttributeNameAwstraceHeader)
+}
+
+// attribute returns the value of the system attribute, empty when not requested
+func (m *SQSMessage) attribute(name string) string {
+	return aws.StringValue(m.rawMessage.Attributes[name])
+}
+
+// timestampAttribute returns the time of the system attribute in epoch milliseconds, the zero time when not requested
+func (m *SQSMessage) timestampAttribute(name string) time.Time {
+	millis, err := strconv.ParseInt(m.attribute(name), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// receiveCount returns the number of times the message has been received, 1 when unknown
+func (m *SQSMessage) receiveCount() int {
+	if count := m.ApproximateReceiveCount(); count > 0 {
+		return count
+	}
+	return 1
+}
+
+// decodeContent reverts the comma separated content encodings of the payload, listed in the order they were applied
+func decodeContent(payload []byte, contentEncoding string) ([]byte, error) {
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch encoding := strings.TrimSpace(encodings[i]); encoding {
+		case codec.Base64Encoding:
+			decoded, err := base64.StdEncoding.DecodeString(string(payload))
+			if err != nil {
+				return nil, err
+			}
+			payload = decoded
+		default:
+			compressor, ok := compression.ForEncoding(encoding)
+			if !ok {
+				return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+			}
+			decompressed, err := compressor.Decompress(payload)
+			if err != nil {
+				return nil, err
+			}
+			payload = decompressed
+		}
+	}
+	return payload, nil
 }
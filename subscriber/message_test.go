@@ -0,0 +1,98 @@
+package subscriber
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/jpillora/backoff"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageNack(t *testing.T) {
+	policy := &backoff.Backoff{Min: 10 * time.Second, Max: time.Hour, Factor: 2}
+
+	tt := []struct {
+		name               string
+		receiveCount       string
+		policy             *backoff.Backoff
+		expectedVisibility int64
+	}{
+		{"First receive", "1", policy, 10},
+		{"Third receive", "3", policy, 40},
+		{"Capped to the maximum delay", "20", policy, 3600},
+		{"Unknown receive count", "", policy, 10},
+		{"Capped to the maximum visibility timeout", "20", &backoff.Backoff{Min: time.Hour, Max: 24 * time.Hour, Factor: 2}, 43200},
+		{"Default policy", "1", nil, 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			visibility := make(chan *sqs.ChangeMessageVisibilityInput, 1)
+			subs := New(Config{})
+			subs.sqs = &sqsMock{visibility: visibility}
+
+			m := newTestMessage(subs, "message")
+			if tc.receiveCount != "" {
+				m.rawMessage.Attributes = map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(tc.receiveCount)}
+			}
+
+			require.NoError(t, m.Nack(tc.policy))
+			input := <-visibility
+			require.Equal(t, tc.expectedVisibility, *input.VisibilityTimeout)
+			require.True(t, m.settled.isSet())
+		})
+	}
+}
+
+func TestMessageSettled(t *testing.T) {
+	mock := &sqsMock{rejectedDeletes: map[string]bool{"rejected": true}}
+	subs := newAckingSubscriber(t, Config{AckFlushInterval: 10 * time.Millisecond}, mock)
+
+	// failed acknowledgements leave the message unsettled
+	m := newTestMessage(subs, "rejected")
+	require.Error(t, m.ChangeMessageVisibility(nil))
+	require.False(t, m.settled.isSet())
+	require.EqualError(t, m.Done(), "ReceiptHandleIsInvalid: invalid receipt handle")
+	require.False(t, m.settled.isSet())
+
+	m = newTestMessage(subs, "deleted")
+	require.NoError(t, m.Done())
+	require.True(t, m.settled.isSet())
+	require.NoError(t, subs.Stop())
+}
+
+func TestMessageSystemAttributes(t *testing.T) {
+	m := &SQSMessage{rawMessage: &sqs.Message{
+		MessageId:     aws.String("id"),
+		ReceiptHandle: aws.String("handle"),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount:          aws.String("2"),
+			sqs.MessageSystemAttributeNameSentTimestamp:                    aws.String("1700000000000"),
+			sqs.MessageSystemAttributeNameApproximateFirstReceiveTimestamp: aws.String("1700000001500"),
+			sqs.MessageSystemAttributeNameMessageGroupId:                   aws.String("group"),
+			sqs.MessageSystemAttributeNameMessageDeduplicationId:           aws.String("dedup"),
+			sqs.MessageSystemAttributeNameSequenceNumber:                   aws.String("18849496460467696128"),
+			sqs.MessageSystemAttributeNameAwstraceHeader:                   aws.String("Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1"),
+		},
+	}}
+
+	require.Equal(t, "id", m.MessageID())
+	require.Equal(t, "handle", m.ReceiptHandle())
+	require.Equal(t, 2, m.ApproximateReceiveCount())
+	require.Equal(t, time.UnixMilli(1700000000000), m.SentTimestamp())
+	require.Equal(t, time.UnixMilli(1700000001500), m.ApproximateFirstReceiveTimestamp())
+	require.Equal(t, "group", m.MessageGroupID())
+	require.Equal(t, "dedup", m.MessageDeduplicationID())
+	require.Equal(t, "18849496460467696128", m.SequenceNumber())
+	require.Equal(t, "Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1", m.AWSTraceHeader())
+
+	// attributes not requested
+	empty := &SQSMessage{rawMessage: &sqs.Message{}}
+	require.Empty(t, empty.MessageID())
+	require.Zero(t, empty.ApproximateReceiveCount())
+	require.True(t, empty.SentTimestamp().IsZero())
+	require.Empty(t, empty.MessageGroupID())
+	require.Equal(t, 1, empty.receiveCount())
+}
@@ -0,0 +1,77 @@
+package subscriber
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Recovery recovers the panics of the message handler, logging them along with their stack trace.
+// The message is left in the queue and delivered again once its visibility timeout expires
+func Recovery(logger Logger) HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, w *Worker, m *SQSMessage) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Printf("level=error msg=%q message_id=%s panic=%q stack=%q\n",
+						"message handler panicked", aws.StringValue(m.rawMessage.MessageId), r, debug.Stack())
+				}
+			}()
+			next(ctx, w, m)
+		}
+	}
+}
+
+// Timeout cancels the context of the message handler once the timeout expires
+func Timeout(timeout time.Duration) HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, w *Worker, m *SQSMessage) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			next(ctx, w, m)
+		}
+	}
+}
+
+// Logging logs every message handled along with its handling duration as key=value pairs
+func Logging(logger Logger) HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, w *Worker, m *SQSMessage) {
+			start := time.Now()
+			logger.Printf("level=info msg=%q message_id=%s\n", "message received", aws.StringValue(m.rawMessage.MessageId))
+			next(ctx, w, m)
+			logger.Printf("level=info msg=%q message_id=%s duration=%s\n",
+				"message handled", aws.StringValue(m.rawMessage.MessageId), time.Since(start))
+		}
+	}
+}
+
+// Latency reports the handling duration of every message to the observe function, e.g. to record latency metrics
+func Latency(observe func(m *SQSMessage, duration time.Duration)) HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, w *Worker, m *SQSMessage) {
+			start := time.Now()
+			next(ctx, w, m)
+			observe(m, time.Since(start))
+		}
+	}
+}
+
+// AckOnSuccess deletes the message once the handler returns, unless the handler panicked or already
+// deleted the message or changed its visibility. Delete errors are logged with the subscriber logger.
+// It must be used after Recovery so that panicking handlers skip the acknowledgement
+func AckOnSuccess() HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, w *Worker, m *SQSMessage) {
+			next(ctx, w, m)
+			if m.settled.isSet() {
+				return
+			}
+			if err := m.Done(); err != nil {
+				m.sub.cfg.Logger.Printf("Error when deleting message from SQS: %v\n", err)
+			}
+		}
+	}
+}
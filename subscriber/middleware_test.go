@@ -0,0 +1,117 @@
+package subscriber
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+type loggerMock struct {
+	lines []string
+}
+
+func (l *loggerMock) Printf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func newTestMessage(subs *Subscriber, id string) *SQSMessage {
+	return &SQSMessage{
+		sub:        subs,
+		rawMessage: &sqs.Message{MessageId: aws.String(id), Body: aws.String("message"), ReceiptHandle: aws.String(id)},
+	}
+}
+
+func TestWorkerUse(t *testing.T) {
+	var calls []string
+	tracing := func(name string) HandlerMiddleware {
+		return func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, w *Worker, m *SQSMessage) {
+				calls = append(calls, name)
+				next(ctx, w, m)
+			}
+		}
+	}
+
+	worker := NewWorker(WorkerConfig{
+		Subscriber:     New(Config{}),
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) { calls = append(calls, "handler") },
+	})
+	worker.Use(tracing("outer"))
+	worker.Use(tracing("inner"))
+
+	worker.handler()(context.TODO(), worker, newTestMessage(worker.config.Subscriber, "1"))
+	require.Equal(t, []string{"outer", "inner", "handler"}, calls)
+}
+
+func TestAckOnSuccess(t *testing.T) {
+	deleted := make(chan *sqs.DeleteMessageInput, 2)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{deleted: deleted}
+	logger := &loggerMock{}
+
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			switch *m.rawMessage.MessageId {
+			case "panic":
+				panic("handler failure")
+			case "retry":
+				require.NoError(t, m.ChangeMessageVisibility(aws.Int64(0)))
+			}
+		},
+	})
+	worker.Use(Recovery(logger), AckOnSuccess())
+	handler := worker.handler()
+
+	handler(context.TODO(), worker, newTestMessage(subs, "success"))
+	require.Equal(t, "success", *(<-deleted).ReceiptHandle)
+
+	// panicking handlers and messages whose visibility was changed are not deleted
+	handler(context.TODO(), worker, newTestMessage(subs, "panic"))
+	handler(context.TODO(), worker, newTestMessage(subs, "retry"))
+	require.Empty(t, deleted)
+
+	require.Len(t, logger.lines, 1)
+	require.Contains(t, logger.lines[0], `msg="message handler panicked" message_id=panic panic="handler failure"`)
+}
+
+func TestTimeout(t *testing.T) {
+	worker := NewWorker(WorkerConfig{
+		Subscriber: New(Config{}),
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			deadline, ok := ctx.Deadline()
+			require.True(t, ok)
+			require.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+		},
+	})
+	worker.Use(Timeout(time.Minute))
+	worker.handler()(context.TODO(), worker, newTestMessage(worker.config.Subscriber, "1"))
+}
+
+func TestLoggingAndLatency(t *testing.T) {
+	var (
+		logger    = &loggerMock{}
+		latencies []time.Duration
+	)
+	worker := NewWorker(WorkerConfig{
+		Subscriber:     New(Config{}),
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) { time.Sleep(time.Millisecond) },
+	})
+	worker.Use(Logging(logger), Latency(func(m *SQSMessage, duration time.Duration) {
+		require.Equal(t, "1", *m.rawMessage.MessageId)
+		latencies = append(latencies, duration)
+	}))
+	worker.handler()(context.TODO(), worker, newTestMessage(worker.config.Subscriber, "1"))
+
+	require.Len(t, latencies, 1)
+	require.True(t, latencies[0] >= time.Millisecond)
+	require.Len(t, logger.lines, 2)
+	require.Equal(t, "level=info msg=\"message received\" message_id=1\n", logger.lines[0])
+	require.True(t, strings.HasPrefix(logger.lines[1], `level=info msg="message handled" message_id=1 duration=`))
+}
@@ -1,30 +1,164 @@
 package subscriber
 
 import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
 type sqsMock struct {
 	queue      <-chan *SQSMessage
 	errorQueue <-chan error
+	sent       chan<- *sqs.SendMessageInput
+	deleted    chan<- *sqs.DeleteMessageInput
+	visibility chan<- *sqs.ChangeMessageVisibilityInput
+
+	// longPoll makes ReceiveMessage wait for a message, an error or the context cancellation
+	longPoll bool
+
+	// visibilityBatches are the ChangeMessageVisibilityBatch calls. The entries of the receipt handles
+	// in failedVisibility fail
+	mu                sync.Mutex
+	visibilityBatches []*sqs.ChangeMessageVisibilityBatchInput
+	failedVisibility  map[string]bool
+
+	// receiveInput is the last ReceiveMessage call
+	receiveInput *sqs.ReceiveMessageInput
+
+	// deleteBatches are the DeleteMessageBatch calls. The entries of the receipt handles in failedDeletes fail
+	// as many times as set, with a sender fault for the ones in rejectedDeletes. The entries of the receipt
+	// handles in unansweredDeletes are left out of the response as many times as set
+	deleteBatches     []*sqs.DeleteMessageBatchInput
+	failedDeletes     map[string]int
+	rejectedDeletes   map[string]bool
+	unansweredDeletes map[string]int
 }
 
-func (s *sqsMock) ReceiveMessage(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+func (s *sqsMock) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	s.mu.Lock()
+	s.receiveInput = input
+	s.mu.Unlock()
+
 	select {
 	case message := <-s.queue:
-		stringMessage := string(message.Body())
-		return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{Body: &stringMessage, ReceiptHandle: &stringMessage}}}, nil
+		return received(message), nil
 	case err := <-s.errorQueue:
 		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	default:
-		return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{}}, nil
+		if !s.longPoll {
+			return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{}}, nil
+		}
 	}
+
+	// wait for a message until the long poll is aborted
+	select {
+	case message := <-s.queue:
+		return received(message), nil
+	case err := <-s.errorQueue:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// received returns the output of a ReceiveMessage call receiving the message, using its body as receipt handle
+func received(message *SQSMessage) *sqs.ReceiveMessageOutput {
+	stringMessage := aws.StringValue(message.rawMessage.Body)
+	return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{Body: &stringMessage, ReceiptHandle: &stringMessage, MessageAttributes: message.MessageAttributes()}}}
 }
 
-func (s *sqsMock) DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+func (s *sqsMock) DeleteMessageWithContext(_ aws.Context, input *sqs.DeleteMessageInput, _ ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	if s.deleted != nil {
+		s.deleted <- input
+	}
 	return nil, nil
 }
 
-func (s *sqsMock) ChangeMessageVisibility(*sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+func (s *sqsMock) ChangeMessageVisibilityWithContext(_ aws.Context, input *sqs.ChangeMessageVisibilityInput, _ ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if s.visibility != nil {
+		s.visibility <- input
+	}
 	return nil, nil
 }
+
+func (s *sqsMock) SendMessageWithContext(_ aws.Context, input *sqs.SendMessageInput, _ ...request.Option) (*sqs.SendMessageOutput, error) {
+	if s.sent != nil {
+		s.sent <- input
+	}
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (s *sqsMock) ChangeMessageVisibilityBatchWithContext(_ aws.Context, input *sqs.ChangeMessageVisibilityBatchInput, _ ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.visibilityBatches = append(s.visibilityBatches, input)
+
+	output := &sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, entry := range input.Entries {
+		if s.failedVisibility[*entry.ReceiptHandle] {
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("ReceiptHandleIsInvalid"), Message: aws.String("invalid receipt handle"), SenderFault: aws.Bool(true),
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, &sqs.ChangeMessageVisibilityBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+// visibilityHandles returns the receipt handles of the messages whose visibility was changed in batches
+func (s *sqsMock) visibilityHandles() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var handles []string
+	for _, input := range s.visibilityBatches {
+		for _, entry := range input.Entries {
+			handles = append(handles, *entry.ReceiptHandle)
+		}
+	}
+	return handles
+}
+
+func (s *sqsMock) DeleteMessageBatchWithContext(_ aws.Context, input *sqs.DeleteMessageBatchInput, _ ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteBatches = append(s.deleteBatches, input)
+
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		handle := *entry.ReceiptHandle
+		switch {
+		case s.unansweredDeletes[handle] > 0:
+			s.unansweredDeletes[handle]--
+		case s.rejectedDeletes[handle]:
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("ReceiptHandleIsInvalid"), Message: aws.String("invalid receipt handle"), SenderFault: aws.Bool(true),
+			})
+		case s.failedDeletes[handle] > 0:
+			s.failedDeletes[handle]--
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("internal error"), SenderFault: aws.Bool(false),
+			})
+		default:
+			output.Successful = append(output.Successful, &sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+		}
+	}
+	return output, nil
+}
+
+// deletedInBatches returns the number of DeleteMessageBatch calls and the receipt handles deleted in them
+func (s *sqsMock) deletedInBatches() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var handles []string
+	for _, input := range s.deleteBatches {
+		for _, entry := range input.Entries {
+			handles = append(handles, *entry.ReceiptHandle)
+		}
+	}
+	return len(s.deleteBatches), handles
+}
@@ -1,16 +1,23 @@
 package subscriber
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/creatorstack/htsqs/blobstore"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/constants"
 	"github.com/jpillora/backoff"
 )
 
@@ -34,9 +41,12 @@ func (b *atomicBool) setTrue() error {
 
 // receiver is the interface to sqsiface.SQSAPI. The only purpose is to be able to mock sqs for testing. See mock_test.go
 type receiver interface {
-	ReceiveMessage(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
-	DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
-	ChangeMessageVisibility(params *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
+	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error)
+	SendMessageWithContext(aws.Context, *sqs.SendMessageInput, ...request.Option) (*sqs.SendMessageOutput, error)
+	ChangeMessageVisibilityWithContext(aws.Context, *sqs.ChangeMessageVisibilityInput, ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error)
+	DeleteMessageBatchWithContext(aws.Context, *sqs.DeleteMessageBatchInput, ...request.Option) (*sqs.DeleteMessageBatchOutput, error)
+	ChangeMessageVisibilityBatchWithContext(aws.Context, *sqs.ChangeMessageVisibilityBatchInput, ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error)
 }
 
 // Logger interface allows to use other loggers than standard log.Logger
@@ -70,8 +80,45 @@ type Config struct {
 	// number of consumers per subscriber
 	NumConsumers int
 
+	// AttributeNames are the system attributes returned along with each message, such as SentTimestamp or
+	// MessageGroupId, see the SQSMessage accessors. Nack relies on ApproximateReceiveCount. Defaults to All
+	AttributeNames []string
+
 	// subscriber logger
 	Logger Logger
+
+	// Codec unmarshals the message payloads without a content type attribute. Defaults to JSON
+	Codec codec.Codec
+
+	// PayloadStore is the blob store offloaded message payloads are read from
+	PayloadStore blobstore.Store
+
+	// DeleteOffloadedPayloads removes offloaded payloads from the PayloadStore once their message is done
+	DeleteOffloadedPayloads bool
+
+	// AckBatching coalesces the messages done into DeleteMessageBatch requests of up to 10 messages,
+	// sent once full or after AckFlushInterval. Pending deletions are flushed on Stop
+	AckBatching bool
+
+	// AckFlushInterval is the maximum time a message deletion waits to be batched. Defaults to 100 milliseconds
+	AckFlushInterval time.Duration
+
+	// AckMaxAttempts is the number of attempts to delete a message failing within a batch. Defaults to 3
+	AckMaxAttempts int
+
+	// VisibilityHeartbeat keeps the messages received invisible while they are handled, extending their
+	// visibility timeout every HeartbeatInterval until they are done or their visibility changed, up to
+	// MaxProcessingTime. Messages consumed by a Worker are no longer extended once their handler returns.
+	// The context of a message whose extension fails is cancelled, see SQSMessage.Context
+	VisibilityHeartbeat bool
+
+	// HeartbeatInterval is the time between two visibility timeout extensions. Defaults to a third of the
+	// visibility timeout
+	HeartbeatInterval time.Duration
+
+	// MaxProcessingTime is the time after which the visibility timeout of a message received is no longer
+	// extended. Defaults to 12 hours, the maximum allowed by AWS SQS
+	MaxProcessingTime time.Duration
 }
 
 // Subscriber is an SQS client that allows a user to
@@ -84,11 +131,31 @@ type Subscriber struct {
 	stopped  atomicBool
 	consumed atomicBool
 	stop     chan error
+	quit     chan struct{}
+
+	// slots limits the number of messages received and not yet released, nil when unlimited
+	slots chan struct{}
+
+	messages chan *SQSMessage
+
+	// unacked holds the received messages not yet deleted nor their visibility changed
+	mu      sync.Mutex
+	unacked map[*SQSMessage]struct{}
+
+	// acker batches the message deletions, nil without AckBatching
+	acker *acker
 }
 
-// Consume starts consuming messages from the SQS queue.
+// Consume starts consuming messages from the SQS queue until the context is cancelled or Stop is called.
+// Both abort the in-flight long polls right away.
 // Returns a channel of SubscriberMessage to consume them and a channel of errors
-func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
+func (s *Subscriber) Consume(ctx context.Context) (<-chan *SQSMessage, <-chan error, error) {
+	return s.consume(ctx, 0)
+}
+
+// consume starts consuming messages from the SQS queue. When maxInFlight is positive, receiving pauses
+// while maxInFlight messages have been received and not yet released, see 'releaseSlots'
+func (s *Subscriber) consume(ctx context.Context, maxInFlight int) (<-chan *SQSMessage, <-chan error, error) {
 	if s.stopped.isSet() {
 		return nil, nil, errors.New("SQS subscriber is already stopped")
 	}
@@ -97,6 +164,26 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 		return nil, nil, errors.New("SQS subscriber is already running")
 	}
 
+	if maxInFlight > 0 {
+		s.slots = make(chan struct{}, maxInFlight)
+	}
+	if s.cfg.AckBatching {
+		s.acker = newAcker(s)
+	}
+	if s.cfg.VisibilityHeartbeat {
+		go s.heartbeat(ctx)
+	}
+
+	// consumers are cancelled by Stop too
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	var wg sync.WaitGroup
 	var messages chan *SQSMessage
 	var errCh chan error
@@ -108,6 +195,7 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 	}
 
 	messages = make(chan *SQSMessage, messagesPerBatchPerConsumer*int64(s.cfg.NumConsumers))
+	s.messages = messages
 	errCh = make(chan error, int64(s.cfg.NumConsumers))
 
 	backoffCounter := backoff.Backoff{
@@ -126,31 +214,64 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 			var msgs *sqs.ReceiveMessageOutput
 			var err error
 
-			for !s.stopped.isSet() {
-				msgs, err = s.sqs.ReceiveMessage(&sqs.ReceiveMessageInput{
+			for ctx.Err() == nil {
+				// only receive as many messages as there are free slots
+				maxMessages := s.cfg.MaxMessagesPerBatch
+				var reserved int64
+				if s.slots != nil {
+					if reserved = s.acquire(ctx, messagesPerBatchPerConsumer); reserved == 0 {
+						continue
+					}
+					maxMessages = aws.Int64(reserved)
+				}
+
+				msgs, err = s.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+					AttributeNames:        aws.StringSlice(s.cfg.AttributeNames),
 					MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
-					MaxNumberOfMessages:   s.cfg.MaxMessagesPerBatch,
+					MaxNumberOfMessages:   maxMessages,
 					QueueUrl:              &s.cfg.SqsQueueURL,
 					WaitTimeSeconds:       s.cfg.TimeoutSeconds,
 					VisibilityTimeout:     s.cfg.VisibilityTimeout,
 				})
 
 				if err != nil {
+					s.releaseSlots(int(reserved))
+					// long poll aborted by the cancellation
+					if ctx.Err() != nil {
+						continue
+					}
 					// Error found, send the error
 					errCh <- err
-					time.Sleep(backoffCfg.Duration())
+					// the backoff is cut short when stopping
+					timer := time.NewTimer(backoffCfg.Duration())
+					select {
+					case <-timer.C:
+					case <-ctx.Done():
+						timer.Stop()
+					}
 					continue
 				}
+				if s.slots != nil {
+					s.releaseSlots(int(reserved) - len(msgs.Messages))
+				}
 
 				if len(msgs.Messages) > 0 {
 					s.cfg.Logger.Printf("Found %d messages on %v\n", len(msgs.Messages), s.cfg.SqsQueueURL)
 				}
 				// for each message, pass to output
 				for _, msg := range msgs.Messages {
-					messages <- &SQSMessage{
-						sub:        s,
-						rawMessage: msg,
+					// messages delayed longer than the AWS SQS maximum are kept in the queue until due
+					if deliverAt, ok := deliverAt(msg); ok && time.Now().Before(deliverAt) {
+						s.releaseSlots(1)
+						if err := s.requeue(ctx, msg, deliverAt); err != nil {
+							errCh <- err
+						}
+						continue
 					}
+
+					message := s.newMessage(msg)
+					s.track(message)
+					messages <- message
 				}
 			}
 		}(i, backoffCounter)
@@ -158,6 +279,7 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 
 	go func() {
 		wg.Wait()
+		cancel()
 		close(messages)
 		close(errCh)
 		s.stop <- nil
@@ -168,13 +290,236 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 	return messages, errCh, nil
 }
 
-// Stop stop gracefully the Subscriber.
-// Blocks until all consumers from the subscriber are gracefully stopped
+// Stop stop gracefully the Subscriber, aborting the in-flight long polls.
+// Blocks until all consumers from the subscriber are gracefully stopped. The messages received
+// but not yet consumed from the messages channel are made visible again to other consumers
 func (s *Subscriber) Stop() error {
+	releaseErr, err := s.shutdown()
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
+
+// shutdown stops the subscriber like Stop, returning the error releasing the messages not yet consumed apart
+// from the error stopping it. The subscriber is stopped whether or not releasing the messages fails
+func (s *Subscriber) shutdown() (releaseErr error, err error) {
 	if err := s.stopped.setTrue(); err != nil {
-		return errors.New("SQS subscriber is already stopped")
+		return nil, errors.New("SQS subscriber is already stopped")
+	}
+	close(s.quit)
+
+	// the messages channel is closed once all consumers are stopped
+	var prefetched []*SQSMessage
+	if s.messages != nil {
+		for message := range s.messages {
+			prefetched = append(prefetched, message)
+		}
+	}
+	err = <-s.stop
+	if s.acker != nil {
+		s.acker.stop()
+	}
+	return s.release(context.Background(), prefetched), err
+}
+
+// ReleaseUnacked makes the received messages not yet deleted nor their visibility changed visible again
+// to other consumers, instead of waiting for their visibility timeout to expire. It is meant to be called
+// on shutdown, once the messages consumed have been handled
+func (s *Subscriber) ReleaseUnacked(ctx context.Context) error {
+	s.mu.Lock()
+	unacked := make([]*SQSMessage, 0, len(s.unacked))
+	for message := range s.unacked {
+		unacked = append(unacked, message)
+	}
+	s.mu.Unlock()
+
+	return s.release(ctx, unacked)
+}
+
+// track records the message as received and not yet acknowledged
+func (s *Subscriber) track(m *SQSMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.receivedAt = time.Now()
+	s.unacked[m] = struct{}{}
+}
+
+// untrack records the message as acknowledged
+func (s *Subscriber) untrack(m *SQSMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.unacked, m)
+}
+
+// release makes the messages visible again right away, returning an error if any of them failed
+func (s *Subscriber) release(ctx context.Context, msgs []*SQSMessage) error {
+	failed := s.changeVisibility(ctx, msgs, 0)
+	for _, message := range msgs {
+		if _, ok := failed[message]; !ok {
+			s.untrack(message)
+		}
+	}
+
+	for _, err := range failed {
+		return fmt.Errorf("changing the visibility of %d messages failed: %w", len(failed), err)
 	}
-	return <-s.stop
+	return nil
+}
+
+// changeVisibility sets the visibility timeout of the messages in batches of 10 through ChangeMessageVisibilityBatch,
+// returning the error of each message whose visibility could not be changed
+func (s *Subscriber) changeVisibility(ctx context.Context, msgs []*SQSMessage, visibilityTimeout int64) map[*SQSMessage]error {
+	failed := make(map[*SQSMessage]error)
+	for start := 0; start < len(msgs); start += constants.MaxBatchSize {
+		end := start + constants.MaxBatchSize
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		batch := msgs[start:end]
+
+		entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0, len(batch))
+		for idx, message := range batch {
+			entries = append(entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(idx)),
+				ReceiptHandle:     message.rawMessage.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(visibilityTimeout),
+			})
+		}
+
+		output, err := s.sqs.ChangeMessageVisibilityBatchWithContext(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+			Entries:  entries,
+			QueueUrl: &s.cfg.SqsQueueURL,
+		})
+		if err != nil {
+			for _, message := range batch {
+				failed[message] = err
+			}
+			continue
+		}
+
+		for _, entry := range output.Failed {
+			if idx, err := strconv.Atoi(aws.StringValue(entry.Id)); err == nil && idx >= 0 && idx < len(batch) {
+				failed[batch[idx]] = fmt.Errorf("%s: %s", aws.StringValue(entry.Code), aws.StringValue(entry.Message))
+			}
+		}
+	}
+	return failed
+}
+
+// acquire blocks until a slot is free and reserves up to max slots, returning the number of slots reserved.
+// It returns 0 once the subscriber is stopped or its context cancelled
+func (s *Subscriber) acquire(ctx context.Context, max int64) int64 {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return 0
+	}
+
+	reserved := int64(1)
+	for ; reserved < max; reserved++ {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			return reserved
+		}
+	}
+	return reserved
+}
+
+// releaseSlots frees the slots of n messages received, once handled or discarded. It is a no-op when the
+// number of messages in flight is unlimited
+func (s *Subscriber) releaseSlots(n int) {
+	if s.slots == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		<-s.slots
+	}
+}
+
+// newMessage creates the SQSMessage of the received message. Its payload is resolved on first access,
+// so that failing to resolve or decode it does not hold up receiving and reaches the message handler
+func (s *Subscriber) newMessage(msg *sqs.Message) *SQSMessage {
+	message := &SQSMessage{sub: s, rawMessage: msg}
+	message.ctx, message.cancel = context.WithCancelCause(context.Background())
+	return message
+}
+
+// resolvePayload returns the payload of the received message, resolving its offloaded payload and decoding
+// its content encoding. Failures that retrying the message cannot fix are permanent errors
+func (s *Subscriber) resolvePayload(ctx context.Context, msg *sqs.Message) ([]byte, error) {
+	body := []byte(aws.StringValue(msg.Body))
+
+	if _, ok := msg.MessageAttributes[constants.OffloadedPayloadAttribute]; ok {
+		if s.cfg.PayloadStore == nil {
+			return nil, errors.New("received an offloaded payload but no payload store is configured")
+		}
+
+		pointer, err := blobstore.ParsePointer(body)
+		if err != nil {
+			return nil, Permanent(fmt.Errorf("parsing offloaded payload pointer: %w", err))
+		}
+		payload, err := s.cfg.PayloadStore.Get(ctx, pointer.Key)
+		if err != nil {
+			err = fmt.Errorf("resolving offloaded payload: %w", err)
+			if errors.Is(err, blobstore.ErrNotFound) {
+				return nil, Permanent(err)
+			}
+			return nil, err
+		}
+		body = payload
+	}
+
+	if attr, ok := msg.MessageAttributes[constants.ContentEncodingAttribute]; ok {
+		decoded, err := decodeContent(body, aws.StringValue(attr.StringValue))
+		if err != nil {
+			return nil, Permanent(fmt.Errorf("decoding payload: %w", err))
+		}
+		body = decoded
+	}
+
+	return body, nil
+}
+
+// requeue sends again the message with the remaining delay until it is due and deletes the received one.
+// The delay is rounded up to the second, so that the message is not received again before it is due
+func (s *Subscriber) requeue(ctx context.Context, msg *sqs.Message, deliverAt time.Time) error {
+	delay := (time.Until(deliverAt) + time.Second - 1).Truncate(time.Second)
+	if delay > constants.MaxDelay {
+		delay = constants.MaxDelay
+	}
+
+	_, err := s.sqs.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		DelaySeconds:      aws.Int64(int64(delay / time.Second)),
+		MessageAttributes: msg.MessageAttributes,
+		MessageBody:       msg.Body,
+		QueueUrl:          &s.cfg.SqsQueueURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &s.cfg.SqsQueueURL,
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	return err
+}
+
+// deliverAt returns the time the message must be delivered at, if it was published with a delay
+// longer than the AWS SQS maximum
+func deliverAt(msg *sqs.Message) (time.Time, bool) {
+	attr, ok := msg.MessageAttributes[constants.DeliverAtAttribute]
+	if !ok || attr == nil || attr.StringValue == nil {
+		return time.Time{}, false
+	}
+
+	millis, err := strconv.ParseInt(*attr.StringValue, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
 }
 
 func defaultSubscriberConfig(cfg *Config) {
@@ -189,10 +534,35 @@ func defaultSubscriberConfig(cfg *Config) {
 	if cfg.Logger == nil {
 		cfg.Logger = log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
 	}
+
+	if cfg.Codec == nil {
+		cfg.Codec = codec.JSON
+	}
+
+	if cfg.AttributeNames == nil {
+		cfg.AttributeNames = []string{sqs.QueueAttributeNameAll}
+	}
+
+	if cfg.AckFlushInterval <= 0 {
+		cfg.AckFlushInterval = defaultAckFlushInterval
+	}
+
+	if cfg.AckMaxAttempts <= 0 {
+		cfg.AckMaxAttempts = defaultAckMaxAttempts
+	}
+
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = visibilityTimeout(cfg) / 3
+	}
+
+	if cfg.MaxProcessingTime <= 0 {
+		cfg.MaxProcessingTime = constants.MaxVisibilityTimeout
+	}
 }
 
 // New creates a new AWS SQS subscriber
 func New(cfg Config) *Subscriber {
 	defaultSubscriberConfig(&cfg)
-	return &Subscriber{cfg: cfg, sqs: sqs.New(cfg.AWSSession), stop: make(chan error, 1)}
+	return &Subscriber{cfg: cfg, sqs: sqs.New(cfg.AWSSession), stop: make(chan error, 1), quit: make(chan struct{}),
+		unacked: make(map[*SQSMessage]struct{})}
 }
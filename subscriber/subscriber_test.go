@@ -1,15 +1,23 @@
 package subscriber
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/creatorstack/htsqs/blobstore"
+	"github.com/creatorstack/htsqs/codec"
+	"github.com/creatorstack/htsqs/compression"
+	"github.com/creatorstack/htsqs/constants"
 	"github.com/stretchr/testify/require"
 )
 
@@ -18,20 +26,21 @@ func TestSubscriber(t *testing.T) {
 	queue := make(chan *SQSMessage)
 	defer close(queue)
 	subs := New(Config{})
-	subs.sqs = &sqsMock{queue: queue}
+	mock := &sqsMock{queue: queue}
+	subs.sqs = mock
 
 	stopErrChannel := make(chan error)
 
 	go func() {
 		for i := 0; i < numMessages; i++ {
 			message := fmt.Sprintf("Message: %d", i)
-			queue <- &SQSMessage{subs, &sqs.Message{Body: &message}}
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
 		}
 		stopErrChannel <- subs.Stop()
 		close(stopErrChannel)
 	}()
 
-	messages, errch, err := subs.Consume()
+	messages, errch, err := subs.Consume(context.TODO())
 	require.NoError(t, err)
 
 	i := 0
@@ -43,11 +52,12 @@ func TestSubscriber(t *testing.T) {
 	}
 	require.NoError(t, <-stopErrChannel)
 	require.NoError(t, <-errch)
-	require.Equal(t, numMessages, i)
+	// messages still buffered on Stop are released instead of being delivered
+	require.Equal(t, numMessages, i+len(mock.visibilityHandles()))
 	require.EqualError(t, subs.Stop(), "SQS subscriber is already stopped")
 
 	// try to start consuming again when the consumer has already been used
-	_, _, err = subs.Consume()
+	_, _, err = subs.Consume(context.TODO())
 	require.EqualError(t, err, "SQS subscriber is already stopped")
 }
 
@@ -72,7 +82,7 @@ func TestSubscriberAlreadyRunning(t *testing.T) {
 
 		queue <- &sqsMessage
 
-		_, _, err := subs.Consume()
+		_, _, err := subs.Consume(context.TODO())
 		errsChannelStart <- err
 		close(errsChannelStart)
 
@@ -80,7 +90,7 @@ func TestSubscriberAlreadyRunning(t *testing.T) {
 		close(errsChannelStop)
 	}()
 
-	messages, _, err := subs.Consume()
+	messages, _, err := subs.Consume(context.TODO())
 	require.NoError(t, err)
 	m := <-messages
 	require.Equal(t, string(m.Body()), stringMessage)
@@ -90,6 +100,197 @@ func TestSubscriberAlreadyRunning(t *testing.T) {
 	require.Nil(t, <-errsChannelStop)
 }
 
+func TestSubscriberDeliverAt(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	sent := make(chan *sqs.SendMessageInput, 1)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{queue: queue, sent: sent}
+
+	delayedMessage := "Delayed message"
+	deliverAt := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	dueMessage := "Due message"
+
+	go func() {
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+			Body: &delayedMessage,
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				constants.DeliverAtAttribute: {DataType: aws.String("Number"), StringValue: &deliverAt},
+			},
+		}}
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &dueMessage}}
+	}()
+
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+
+	m := <-messages
+	require.Equal(t, dueMessage, string(m.Body()))
+
+	requeued := <-sent
+	require.Equal(t, delayedMessage, *requeued.MessageBody)
+	require.Equal(t, int64(constants.MaxDelay/time.Second), *requeued.DelaySeconds)
+	require.Equal(t, deliverAt, *requeued.MessageAttributes[constants.DeliverAtAttribute].StringValue)
+	require.NoError(t, subs.Stop())
+}
+
+func TestSubscriberRequeueRoundsUp(t *testing.T) {
+	sent := make(chan *sqs.SendMessageInput, 1)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{sent: sent}
+
+	// less than a second left still delays the message until it is due
+	require.NoError(t, subs.requeue(context.TODO(), &sqs.Message{Body: aws.String("message")}, time.Now().Add(300*time.Millisecond)))
+	require.Equal(t, int64(1), *(<-sent).DelaySeconds)
+}
+
+func TestSubscriberOffloadedPayload(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	store := blobstore.NewFileStore(t.TempDir())
+	subs := New(Config{PayloadStore: store, DeleteOffloadedPayloads: true})
+	subs.sqs = &sqsMock{queue: queue}
+
+	payload := `{"msg":"large message"}`
+	pointerMessage, err := blobstore.Offload(context.TODO(), store, []byte(payload))
+	require.NoError(t, err)
+	pointerString := string(pointerMessage)
+
+	go func() {
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+			Body: &pointerString,
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				constants.OffloadedPayloadAttribute: {DataType: aws.String("Number"), StringValue: aws.String("23")},
+			},
+		}}
+	}()
+
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+
+	m := <-messages
+	require.Equal(t, payload, string(m.Body()))
+	require.NoError(t, m.Done())
+
+	pointer, err := blobstore.ParsePointer(pointerMessage)
+	require.NoError(t, err)
+	_, err = store.Get(context.TODO(), pointer.Key)
+	require.Equal(t, blobstore.ErrNotFound, err)
+	require.NoError(t, subs.Stop())
+}
+
+func TestSubscriberMissingOffloadedPayload(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	store := blobstore.NewFileStore(t.TempDir())
+	subs := New(Config{PayloadStore: store, NumConsumers: 1})
+	subs.sqs = &sqsMock{queue: queue}
+
+	pointerMessage := `{"key":"missing","size":23}`
+	dueMessage := "Due message"
+
+	go func() {
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+			Body: &pointerMessage,
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				constants.OffloadedPayloadAttribute: {DataType: aws.String("Number"), StringValue: aws.String("23")},
+			},
+		}}
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &dueMessage}}
+	}()
+
+	messages, errs, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+
+	// the message whose payload cannot be resolved is delivered with the error, without holding up the next ones
+	m := <-messages
+	require.ErrorIs(t, m.PayloadErr(), blobstore.ErrNotFound)
+	require.True(t, IsPermanent(m.PayloadErr()))
+	require.Equal(t, pointerMessage, string(m.Body()))
+	var decoded map[string]string
+	require.ErrorIs(t, m.Decode(&decoded), blobstore.ErrNotFound)
+
+	m = <-messages
+	require.NoError(t, m.PayloadErr())
+	require.Equal(t, dueMessage, string(m.Body()))
+	require.Empty(t, errs)
+	require.NoError(t, subs.Stop())
+}
+
+func TestSubscriberDecode(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{queue: queue}
+
+	payload, err := codec.MessagePack.Marshal(map[string]string{"msg": "message"})
+	require.NoError(t, err)
+	encodedPayload := base64.StdEncoding.EncodeToString(payload)
+	jsonPayload := `{"msg":"json message"}`
+
+	go func() {
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+			Body: &encodedPayload,
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				constants.ContentTypeAttribute:     {DataType: aws.String("String"), StringValue: aws.String(codec.MessagePackContentType)},
+				constants.ContentEncodingAttribute: {DataType: aws.String("String"), StringValue: aws.String(codec.Base64Encoding)},
+			},
+		}}
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &jsonPayload}}
+	}()
+
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+
+	var decoded map[string]string
+	m := <-messages
+	require.Equal(t, payload, m.Body())
+	require.NoError(t, m.Decode(&decoded))
+	require.Equal(t, map[string]string{"msg": "message"}, decoded)
+
+	m = <-messages
+	require.NoError(t, m.Decode(&decoded))
+	require.Equal(t, map[string]string{"msg": "json message"}, decoded)
+	require.NoError(t, subs.Stop())
+}
+
+func TestSubscriberDecompression(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{queue: queue}
+
+	payload := `{"msg":"compressed message"}`
+	compressed, err := compression.Gzip.Compress([]byte(payload))
+	require.NoError(t, err)
+	encodedPayload := base64.StdEncoding.EncodeToString(compressed)
+
+	go func() {
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+			Body: &encodedPayload,
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				constants.ContentEncodingAttribute: {DataType: aws.String("String"), StringValue: aws.String("gzip, base64")},
+			},
+		}}
+	}()
+
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+
+	m := <-messages
+	require.Equal(t, payload, string(m.Body()))
+	require.NoError(t, subs.Stop())
+}
+
+// requireSameLogger checks both loggers are *log.Logger with the same output, prefix and flags. Since Go 1.19,
+// log.Logger holds its prefix behind an atomic pointer, so that two loggers created alike are no longer equal
+func requireSameLogger(t *testing.T, expected, actual Logger) {
+	expectedLogger, logger := expected.(*log.Logger), actual.(*log.Logger)
+	require.Equal(t, expectedLogger.Writer(), logger.Writer())
+	require.Equal(t, expectedLogger.Prefix(), logger.Prefix())
+	require.Equal(t, expectedLogger.Flags(), logger.Flags())
+}
+
 func TestSubscriberDefaults(t *testing.T) {
 
 	tt := []struct {
@@ -99,13 +300,13 @@ func TestSubscriberDefaults(t *testing.T) {
 	}{
 		{
 			"Custom parameters",
-			Config{AWSSession: session.Must(session.NewSession()), MaxMessagesPerBatch: aws.Int64(1), TimeoutSeconds: aws.Int64(1), VisibilityTimeout: aws.Int64(1), NumConsumers: 1, Logger: log.New(os.Stderr, "", log.LstdFlags)},
-			Config{MaxMessagesPerBatch: aws.Int64(1), TimeoutSeconds: aws.Int64(1), VisibilityTimeout: aws.Int64(1), NumConsumers: 1, Logger: log.New(os.Stderr, "", log.LstdFlags)},
+			Config{AWSSession: session.Must(session.NewSession()), MaxMessagesPerBatch: aws.Int64(1), TimeoutSeconds: aws.Int64(1), VisibilityTimeout: aws.Int64(1), NumConsumers: 1, AttributeNames: []string{"SentTimestamp"}, Logger: log.New(os.Stderr, "", log.LstdFlags), Codec: codec.MessagePack, AckFlushInterval: time.Second, AckMaxAttempts: 5, HeartbeatInterval: time.Second, MaxProcessingTime: time.Minute},
+			Config{MaxMessagesPerBatch: aws.Int64(1), TimeoutSeconds: aws.Int64(1), VisibilityTimeout: aws.Int64(1), NumConsumers: 1, AttributeNames: []string{"SentTimestamp"}, Logger: log.New(os.Stderr, "", log.LstdFlags), Codec: codec.MessagePack, AckFlushInterval: time.Second, AckMaxAttempts: 5, HeartbeatInterval: time.Second, MaxProcessingTime: time.Minute},
 		},
 		{
 			"Use defaults parameters",
 			Config{},
-			Config{MaxMessagesPerBatch: nil, TimeoutSeconds: nil, VisibilityTimeout: nil, NumConsumers: 3, Logger: log.New(os.Stdout, "", log.LstdFlags|log.LUTC)},
+			Config{MaxMessagesPerBatch: nil, TimeoutSeconds: nil, VisibilityTimeout: nil, NumConsumers: 3, AttributeNames: []string{"All"}, Logger: log.New(os.Stdout, "", log.LstdFlags|log.LUTC), Codec: codec.JSON, AckFlushInterval: 100 * time.Millisecond, AckMaxAttempts: 3, HeartbeatInterval: 10 * time.Second, MaxProcessingTime: 12 * time.Hour},
 		},
 	}
 
@@ -126,8 +327,148 @@ func TestSubscriberDefaults(t *testing.T) {
 				require.Equal(t, initialAWSSession, tc.sqsConfig.AWSSession)
 				tc.expectedAfterDefaults.AWSSession = initialAWSSession
 			}
+			// Check logger conf
+			requireSameLogger(t, tc.expectedAfterDefaults.Logger, tc.sqsConfig.Logger)
+			tc.sqsConfig.Logger, tc.expectedAfterDefaults.Logger = nil, nil
 			require.Exactly(t, tc.sqsConfig, tc.expectedAfterDefaults)
 
 		})
 	}
 }
+
+func TestSubscriberReleasePrefetched(t *testing.T) {
+	queue := make(chan *SQSMessage, 3)
+	subs := New(Config{NumConsumers: 1, MaxMessagesPerBatch: aws.Int64(5)})
+	mock := &sqsMock{queue: queue}
+	subs.sqs = mock
+
+	for i := 0; i < 3; i++ {
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String(strconv.Itoa(i))}}
+	}
+
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+
+	consumed := <-messages
+	require.Eventually(t, func() bool { return len(queue) == 0 && len(messages) == 2 }, time.Second, 10*time.Millisecond)
+
+	// the two messages received but never consumed are released on Stop
+	require.NoError(t, subs.Stop())
+	require.ElementsMatch(t, []string{"1", "2"}, mock.visibilityHandles())
+	require.Equal(t, "0", string(consumed.Body()))
+	for _, input := range mock.visibilityBatches {
+		for _, entry := range input.Entries {
+			require.Equal(t, int64(0), *entry.VisibilityTimeout)
+		}
+	}
+
+	// the message consumed but never acknowledged is released once handled
+	require.NoError(t, subs.ReleaseUnacked(context.TODO()))
+	require.ElementsMatch(t, []string{"0", "1", "2"}, mock.visibilityHandles())
+
+	// nothing is left to release
+	require.NoError(t, subs.ReleaseUnacked(context.TODO()))
+	require.Len(t, mock.visibilityHandles(), 3)
+}
+
+func TestSubscriberReleasePrefetchedFailure(t *testing.T) {
+	queue := make(chan *SQSMessage, 1)
+	subs := New(Config{NumConsumers: 1, MaxMessagesPerBatch: aws.Int64(5), AckBatching: true})
+	mock := &sqsMock{queue: queue, failedVisibility: map[string]bool{"prefetched": true}}
+	subs.sqs = mock
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("prefetched"), ReceiptHandle: aws.String("prefetched")}}
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(messages) == 1 }, time.Second, 10*time.Millisecond)
+
+	// the subscriber is stopped even though releasing the message fails, the error being returned apart
+	releaseErr, err := subs.shutdown()
+	require.NoError(t, err)
+	require.EqualError(t, releaseErr, "changing the visibility of 1 messages failed: ReceiptHandleIsInvalid: invalid receipt handle")
+	require.Equal(t, errAckerStopped, subs.acker.delete(context.TODO(), newTestMessage(subs, "late")))
+	require.EqualError(t, subs.Stop(), "SQS subscriber is already stopped")
+}
+
+func TestSubscriberReleaseUnackedSkipsDone(t *testing.T) {
+	queue := make(chan *SQSMessage, 2)
+	subs := New(Config{NumConsumers: 1})
+	mock := &sqsMock{queue: queue}
+	subs.sqs = mock
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("done")}}
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("pending")}}
+
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+
+	first, second := <-messages, <-messages
+	require.NoError(t, first.Done())
+	require.NoError(t, subs.Stop())
+	require.NoError(t, subs.ReleaseUnacked(context.TODO()))
+	require.Equal(t, "pending", string(second.Body()))
+	require.Equal(t, []string{"pending"}, mock.visibilityHandles())
+}
+
+func TestSubscriberStopAbortsLongPoll(t *testing.T) {
+	subs := New(Config{})
+	subs.sqs = &sqsMock{longPoll: true}
+
+	messages, errch, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+
+	// Stop does not wait for the long polls to expire
+	stopped := make(chan error)
+	go func() {
+		stopped <- subs.Stop()
+	}()
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not abort the in-flight long polls")
+	}
+
+	_, ok := <-messages
+	require.False(t, ok)
+	// aborted long polls are not reported as errors
+	require.NoError(t, <-errch)
+}
+
+func TestSubscriberContextCancel(t *testing.T) {
+	subs := New(Config{})
+	subs.sqs = &sqsMock{longPoll: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, errch, err := subs.Consume(ctx)
+	require.NoError(t, err)
+
+	// cancelling the context stops the consumers
+	cancel()
+	select {
+	case _, ok := <-messages:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("cancelling the context did not stop the consumers")
+	}
+	require.NoError(t, <-errch)
+	require.NoError(t, subs.Stop())
+}
+
+func TestSubscriberAttributeNames(t *testing.T) {
+	queue := make(chan *SQSMessage, 1)
+	subs := New(Config{AttributeNames: []string{sqs.MessageSystemAttributeNameSentTimestamp}})
+	mock := &sqsMock{queue: queue}
+	subs.sqs = mock
+
+	messages, _, err := subs.Consume(context.TODO())
+	require.NoError(t, err)
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String("message")}}
+	<-messages
+	require.NoError(t, subs.Stop())
+
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	require.Equal(t, []*string{aws.String(sqs.MessageSystemAttributeNameSentTimestamp)}, mock.receiveInput.AttributeNames)
+}
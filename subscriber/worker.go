@@ -3,12 +3,28 @@ package subscriber
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jpillora/backoff"
 )
 
 // ErrWorkerClosed is returned by the Worker 'Start' method after a call to 'Stop'.
 var ErrWorkerClosed = errors.New("worker closed")
 
+// AbandonedError is returned by the Worker 'Stop' method when handlers are still running once its context is done
+type AbandonedError struct {
+
+	// number of messages whose handler was still running
+	Abandoned int
+}
+
+func (e *AbandonedError) Error() string {
+	return fmt.Sprintf("worker stopped with %d messages abandoned", e.Abandoned)
+}
+
 func defaultMessageHandler(ctx context.Context, w *Worker, m *SQSMessage) {
 	log.Printf("Message received: '%s'", string(m.Body()))
 	if err := m.Done(); err != nil {
@@ -18,9 +34,19 @@ func defaultMessageHandler(ctx context.Context, w *Worker, m *SQSMessage) {
 
 func defaultErrorHandler(ctx context.Context, w *Worker, e error) {
 	log.Printf("Error when receiving messages from SQS: %v", e)
-	w.lastErr <- e
+	// only the first error is returned by 'Start'
+	select {
+	case w.lastErr <- e:
+	default:
+	}
 }
 
+// MessageHandler handles the messages received by a Worker
+type MessageHandler func(context.Context, *Worker, *SQSMessage)
+
+// HandlerMiddleware wraps a MessageHandler, e.g. to add logging or panic recovery around it
+type HandlerMiddleware func(next MessageHandler) MessageHandler
+
 // WorkerConfig is the worker startup config
 type WorkerConfig struct {
 
@@ -28,59 +54,211 @@ type WorkerConfig struct {
 	Subscriber *Subscriber
 
 	// SQS Message Handler
-	MessageHandler func(context.Context, *Worker, *SQSMessage)
+	MessageHandler MessageHandler
+
+	// Handler is an error returning message handler acknowledging the messages according to the
+	// returned error. It takes precedence over MessageHandler when set
+	Handler Handler
+
+	// RetryBackoff sets the visibility timeout of the messages whose Handler failed with a retryable error,
+	// growing with their receive count. Defaults to an exponential backoff from 1 second up to 15 minutes
+	RetryBackoff *backoff.Backoff
+
+	// DeadLetterSink receives the messages whose Handler failed with a permanent error
+	DeadLetterSink DeadLetterSink
+
+	// MaxConcurrency is the number of messages handled concurrently by a pool of handlers. While every
+	// handler is busy, the subscriber stops receiving messages. Concurrency is unlimited when 0
+	MaxConcurrency int
 
 	// SQS Error Handler
 	ErrorHandler func(context.Context, *Worker, error)
 }
 
 func defaultWorkerConfig(cfg *WorkerConfig) {
+	if cfg.Handler != nil {
+		cfg.MessageHandler = handlerMessageHandler(cfg.Handler)
+	}
 	if cfg.MessageHandler == nil {
 		cfg.MessageHandler = defaultMessageHandler
 	}
 	if cfg.ErrorHandler == nil {
 		cfg.ErrorHandler = defaultErrorHandler
 	}
+	if cfg.RetryBackoff == nil {
+		cfg.RetryBackoff = defaultRetryBackoff()
+	}
 }
 
 // Worker represents a SQS worker service
 type Worker struct {
-	lastErr chan error
-	config  *WorkerConfig
+	lastErr     chan error
+	config      *WorkerConfig
+	middlewares []HandlerMiddleware
+
+	// errorsHandled is closed once every receive error has gone through the error handler
+	errorsHandled chan struct{}
+
+	mu         sync.Mutex
+	cancel     context.CancelFunc
+	dispatched chan struct{}
+	inFlight   sync.WaitGroup
+	active     int64
+}
+
+// Use adds middlewares wrapping the message handler. The first middleware is the outermost one,
+// handling the messages before the others. Middlewares must be added before calling 'Start'
+func (w *Worker) Use(mws ...HandlerMiddleware) {
+	w.middlewares = append(w.middlewares, mws...)
+}
+
+// handler returns the message handler wrapped with the middlewares
+func (w *Worker) handler() MessageHandler {
+	handler := w.config.MessageHandler
+	for i := len(w.middlewares) - 1; i >= 0; i-- {
+		handler = w.middlewares[i](handler)
+	}
+	return handler
 }
 
 // Start triggers the process to start consuming messages from the SQS subscriber.
-// Blocks until `lastErr` is set or `Stop()` is called
+// Blocks until `lastErr` is set or `Stop()` is called. When the context is cancelled, the subscriber stops
+// receiving and Start returns the context error once the in-flight handlers have returned. The messages
+// received but not handled yet, and the ones left unacknowledged by their handlers, are then made visible again
 func (w *Worker) Start(ctx context.Context) error {
 
-	sqsMessages, errorCh, err := w.config.Subscriber.Consume()
+	sqsMessages, errorCh, err := w.config.Subscriber.consume(ctx, w.config.MaxConcurrency)
 	if err != nil {
 		return err
 	}
 
+	// handlers are cancelled when 'Stop' gives up waiting for them
+	handlerCtx, cancel := context.WithCancel(ctx)
+	w.mu.Lock()
+	w.cancel = cancel
+	w.mu.Unlock()
+
 	// Process all errors in a goroutine
 	go func() {
+		defer close(w.errorsHandled)
 		for err := range errorCh {
 			w.config.ErrorHandler(ctx, w, err)
 		}
 	}()
 
-	// Process each message in a goroutine
-	for message := range sqsMessages {
-		go w.config.MessageHandler(ctx, w, message)
+	handler := w.handler()
+	if w.config.MaxConcurrency > 0 {
+		// Process the messages with a pool of goroutines, releasing the subscriber slot of each message once handled
+		var wg sync.WaitGroup
+		for i := 0; i < w.config.MaxConcurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for message := range sqsMessages {
+					// messages left once the handlers have been cancelled are not handled
+					if handlerCtx.Err() == nil {
+						w.inFlight.Add(1)
+						w.handle(handlerCtx, handler, message)
+					}
+					w.config.Subscriber.releaseSlots(1)
+				}
+			}()
+		}
+		wg.Wait()
+	} else {
+		// Process each message in a goroutine
+		for message := range sqsMessages {
+			// messages left once the handlers have been cancelled are not handled
+			if handlerCtx.Err() == nil {
+				w.inFlight.Add(1)
+				go w.handle(handlerCtx, handler, message)
+			}
+		}
+	}
+	close(w.dispatched)
+
+	// a receive error reported meanwhile must not skip waiting for the handlers once the context is cancelled
+	if ctx.Err() == nil {
+		select {
+		case err := <-w.lastErr:
+			return err
+		case <-ctx.Done():
+		}
+	}
+
+	w.inFlight.Wait()
+	// messages left unacknowledged, handled or not, are made visible again right away like on 'Stop'
+	if err := w.config.Subscriber.ReleaseUnacked(context.WithoutCancel(ctx)); err != nil {
+		return fmt.Errorf("%w: %w", ctx.Err(), err)
 	}
+	return ctx.Err()
+}
+
+// handle runs the handler, tracking the message as in flight until it returns
+func (w *Worker) handle(ctx context.Context, handler MessageHandler, m *SQSMessage) {
+	atomic.AddInt64(&w.active, 1)
+	defer func() {
+		atomic.AddInt64(&w.active, -1)
+		w.inFlight.Done()
+	}()
 
-	return <-w.lastErr
+	// the handler is cancelled along with the message context, see VisibilityHeartbeat
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(m.Context(), func() { cancel(context.Cause(m.Context())) })
+	defer stop()
+
+	// messages left unacknowledged by their handler are no longer extended
+	defer m.handled.setTrue()
+
+	handler(ctx, w, m)
 }
 
-// Stop gracefully stops the subscriber.
-func (w *Worker) Stop() error {
-	if err := w.config.Subscriber.Stop(); err != nil {
+// Stop gracefully stops the subscriber and waits for the in-flight handlers to finish until the context is done.
+// The context of the handlers still running at that point is cancelled and an AbandonedError is returned
+// with the number of messages abandoned. Messages left unacknowledged are made visible again right away
+func (w *Worker) Stop(ctx context.Context) error {
+	// failing to release the messages not yet consumed does not keep the worker from stopping
+	releaseErr, err := w.config.Subscriber.shutdown()
+	if err != nil {
 		return err
 	}
-	w.lastErr <- ErrWorkerClosed
+
+	drained := make(chan struct{})
+	go func() {
+		<-w.dispatched
+		w.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		w.mu.Lock()
+		if w.cancel != nil {
+			w.cancel()
+		}
+		w.mu.Unlock()
+		err = &AbandonedError{Abandoned: int(atomic.LoadInt64(&w.active))}
+	}
+
+	// messages left unacknowledged by their handlers are made visible again right away, even past the deadline,
+	// along with the messages not yet consumed whose release failed
+	if unackedErr := w.config.Subscriber.ReleaseUnacked(context.WithoutCancel(ctx)); unackedErr != nil && releaseErr == nil {
+		releaseErr = unackedErr
+	}
+	if err == nil {
+		err = releaseErr
+	}
+
+	// the error handler is done sending to lastErr once the receive errors are handled
+	<-w.errorsHandled
+	select {
+	case w.lastErr <- ErrWorkerClosed:
+	default:
+	}
 	close(w.lastErr)
-	return nil
+	return err
 }
 
 // Config returns current configuration
@@ -91,5 +269,10 @@ func (w *Worker) Config() *WorkerConfig {
 // NewWorker creates a new Worker based on the given configuration that process messages from AWS SQS
 func NewWorker(conf WorkerConfig) *Worker {
 	defaultWorkerConfig(&conf)
-	return &Worker{lastErr: make(chan error, 1), config: &conf}
+	return &Worker{
+		lastErr:       make(chan error, 1),
+		config:        &conf,
+		errorsHandled: make(chan struct{}),
+		dispatched:    make(chan struct{}),
+	}
 }
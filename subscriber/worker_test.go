@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/stretchr/testify/require"
 )
@@ -34,13 +37,13 @@ func TestWorker(t *testing.T) {
 				},
 			}
 		}
-		errsChannelStop <- worker.Stop()
+		errsChannelStop <- worker.Stop(context.TODO())
 		close(errsChannelStop)
 	}()
 
 	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
 	require.NoError(t, <-errsChannelStop)
-	require.EqualError(t, worker.Stop(), "SQS subscriber is already stopped")
+	require.EqualError(t, worker.Stop(context.TODO()), "SQS subscriber is already stopped")
 	require.EqualError(t, worker.Start(context.TODO()), "SQS subscriber is already stopped")
 }
 
@@ -66,7 +69,7 @@ func TestWorkerAlreadyRunning(t *testing.T) {
 				Body: &message,
 			},
 		}
-		errsChannelStop <- worker.Stop()
+		errsChannelStop <- worker.Stop(context.TODO())
 		close(errsChannelStop)
 	}()
 
@@ -99,7 +102,257 @@ func TestWorkerError(t *testing.T) {
 
 	AWSError := errors.New("AWS very bad error")
 	errorQueue <- AWSError
-	require.NoError(t, worker.Stop())
+	// the error is reported once the consumer gets it back from the receive call
+	require.Eventually(t, func() bool { return len(worker.lastErr) == 1 }, time.Second, time.Millisecond)
+
+	// stopping does not wait for the receive backoff to elapse
+	start := time.Now()
+	require.NoError(t, worker.Stop(context.TODO()))
+	require.Less(t, time.Since(start), 500*time.Millisecond)
 	require.EqualError(t, <-errsChannelStart, AWSError.Error())
 
 }
+
+func TestWorkerMaxConcurrency(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	subs := New(Config{MaxMessagesPerBatch: aws.Int64(10)})
+	subs.sqs = &sqsMock{queue: queue}
+
+	var (
+		running    int32
+		maxRunning int32
+		received   int32
+		release    = make(chan struct{})
+	)
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&running, -1)
+		},
+		MaxConcurrency: 2,
+	})
+
+	errsChannelStart := make(chan error)
+	go func() {
+		errsChannelStart <- worker.Start(context.TODO())
+	}()
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			message := fmt.Sprintf("Message: %d", i)
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+			atomic.AddInt32(&received, 1)
+		}
+	}()
+
+	// receiving pauses while every handler is busy
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&received) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.EqualValues(t, 2, atomic.LoadInt32(&received))
+
+	close(release)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&received) == 5 }, time.Second, time.Millisecond)
+	require.NoError(t, worker.Stop(context.TODO()))
+	require.Equal(t, ErrWorkerClosed, <-errsChannelStart)
+	require.EqualValues(t, 2, atomic.LoadInt32(&maxRunning))
+}
+
+func TestWorkerStopDrain(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	subs := New(Config{})
+	mock := &sqsMock{queue: queue}
+	subs.sqs = mock
+
+	var handled int32
+	started := make(chan struct{})
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			atomic.AddInt32(&handled, 1)
+		},
+	})
+
+	errsChannelStart := make(chan error)
+	go func() {
+		errsChannelStart <- worker.Start(context.TODO())
+	}()
+
+	message := "message"
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+
+	<-started
+
+	// Stop waits for the in-flight handler to finish, the message left unacknowledged is then released
+	require.NoError(t, worker.Stop(context.TODO()))
+	require.EqualValues(t, 1, atomic.LoadInt32(&handled))
+	require.Equal(t, []string{message}, mock.visibilityHandles())
+	require.Equal(t, ErrWorkerClosed, <-errsChannelStart)
+}
+
+func TestWorkerStopAbandon(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{queue: queue}
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+		},
+	})
+
+	errsChannelStart := make(chan error)
+	go func() {
+		errsChannelStart <- worker.Start(context.TODO())
+	}()
+
+	message := "message"
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+	<-started
+
+	// the handler still running at the deadline is cancelled and reported as abandoned
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	err := worker.Stop(ctx)
+	var abandonedErr *AbandonedError
+	require.True(t, errors.As(err, &abandonedErr))
+	require.Equal(t, 1, abandonedErr.Abandoned)
+	require.Equal(t, "worker stopped with 1 messages abandoned", err.Error())
+
+	<-cancelled
+	require.Equal(t, ErrWorkerClosed, <-errsChannelStart)
+}
+
+func TestWorkerStartContextCancel(t *testing.T) {
+	for _, maxConcurrency := range []int{0, 1} {
+		t.Run(fmt.Sprintf("MaxConcurrency %d", maxConcurrency), func(t *testing.T) {
+			queue := make(chan *SQSMessage)
+			subs := New(Config{})
+			mock := &sqsMock{queue: queue, longPoll: true}
+			subs.sqs = mock
+
+			started := make(chan struct{})
+			handled := make(chan struct{})
+			worker := NewWorker(WorkerConfig{
+				Subscriber:     subs,
+				MaxConcurrency: maxConcurrency,
+				MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+					close(started)
+					<-ctx.Done()
+					close(handled)
+				},
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errsChannelStart := make(chan error)
+			go func() {
+				errsChannelStart <- worker.Start(ctx)
+			}()
+
+			message := "message"
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message, ReceiptHandle: &message}}
+			<-started
+
+			// Start returns once the in-flight handler has returned
+			cancel()
+			require.Equal(t, context.Canceled, <-errsChannelStart)
+			select {
+			case <-handled:
+			default:
+				t.Fatal("Start returned before the in-flight handler")
+			}
+			// the message left unacknowledged is released
+			require.Equal(t, []string{message}, mock.visibilityHandles())
+		})
+	}
+}
+
+func TestWorkerStartContextCancelAcks(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	subs := New(Config{AckBatching: true})
+	mock := &sqsMock{queue: queue, longPoll: true}
+	subs.sqs = mock
+
+	started := make(chan struct{})
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: HandlerFunc(func(ctx context.Context, m *SQSMessage) error {
+			close(started)
+			<-ctx.Done()
+			return nil
+		}),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errsChannelStart := make(chan error)
+	go func() {
+		errsChannelStart <- worker.Start(ctx)
+	}()
+
+	message := "message"
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message, ReceiptHandle: &message}}
+	<-started
+
+	// the message handled successfully during shutdown is deleted, not released
+	cancel()
+	require.Equal(t, context.Canceled, <-errsChannelStart)
+	_, handles := mock.deletedInBatches()
+	require.Equal(t, []string{message}, handles)
+	require.Empty(t, mock.visibilityHandles())
+	require.NoError(t, subs.Stop())
+}
+
+func TestWorkerStartContextCancelAfterError(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	errorQueue := make(chan error)
+	subs := New(Config{NumConsumers: 1})
+	mock := &sqsMock{queue: queue, errorQueue: errorQueue, longPoll: true}
+	subs.sqs = mock
+
+	started := make(chan struct{})
+	handled := make(chan struct{})
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			close(started)
+			<-ctx.Done()
+			close(handled)
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errsChannelStart := make(chan error)
+	go func() {
+		errsChannelStart <- worker.Start(ctx)
+	}()
+
+	message := "message"
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message, ReceiptHandle: &message}}
+	<-started
+	errorQueue <- errors.New("AWS very bad error")
+	require.Eventually(t, func() bool { return len(worker.lastErr) == 1 }, time.Second, time.Millisecond)
+
+	// the receive error does not keep Start from waiting for the handler and releasing its message
+	cancel()
+	require.Equal(t, context.Canceled, <-errsChannelStart)
+	select {
+	case <-handled:
+	default:
+		t.Fatal("Start returned before the in-flight handler")
+	}
+	require.Equal(t, []string{message}, mock.visibilityHandles())
+}